@@ -7,16 +7,19 @@ import (
 	"time"
 )
 
+// AuthMiddleware verifies HS256 tokens signed with signingKey
 func AuthMiddleware(signingKey []byte) *JWTMiddleware {
+	return AuthMiddlewareWithKeys(KeySet{{Algorithm: HS256, Key: signingKey}})
+}
+
+// AuthMiddlewareWithKeys verifies tokens with keys of key set
+func AuthMiddlewareWithKeys(keys KeySet) *JWTMiddleware {
 	return New(MiddlewareOptions{
 		Extractor: FromFirst(
 			FromAuthHeader,
 			FromParameter("token"),
 		),
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return signingKey, nil
-		},
-		SigningMethod:       jwt.SigningMethodHS256,
+		Keys:                keys,
 		CredentialsOptional: true,
 	})
 }
@@ -24,15 +27,30 @@ func AuthMiddleware(signingKey []byte) *JWTMiddleware {
 type Token struct {
 	ID      string `json:"id"`
 	Expires int64  `json:"expires"`
+
+	Refresh        string `json:"refresh,omitempty"` // exchanged for new tokens at /auth/refresh
+	RefreshExpires int64  `json:"refreshExpires,omitempty"`
 }
 
 var (
 	ErrIllegalAction = errors.New("illegal action")
 )
 
+// NewUserToken starts new session of user with access token and refresh token
 func (c *Context) NewUserToken(userKey string, userRole Role) error {
-	var err error
-	c.Token, err = newToken(userKey, userRole)
+	return c.newUserToken(userKey, userRole, false)
+}
+
+// newUserToken starts new session; secondFactor is set if user passed two-factor authentication
+func (c *Context) newUserToken(userKey string, userRole Role, secondFactor bool) error {
+	if len(userKey) == 0 || len(userRole) == 0 {
+		return ErrIllegalAction
+	}
+	family, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	c.Token, err = newSessionToken(c.Context, userKey, userRole, family, secondFactor)
 	return err
 }
 
@@ -43,8 +61,8 @@ func newToken(userKey string, userRole Role) (Token, error) {
 		return tkn, ErrIllegalAction
 	}
 
-	var exp = time.Now().Add(time.Hour * 12).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	var exp = time.Now().Add(AccessTokenTTL).Unix()
+	signed, err := signingKeys.sign(jwt.MapClaims{
 		"aud": "api",
 		"nbf": time.Now().Add(-time.Minute).Unix(),
 		"exp": exp,
@@ -53,32 +71,28 @@ func newToken(userKey string, userRole Role) (Token, error) {
 		"sub": userKey,
 		"rol": userRole,
 	})
-
-	signed, err := token.SignedString(signingKey)
 	if err != nil {
 		return tkn, err
 	}
 
-	return Token{signed, exp}, nil
+	return Token{ID: signed, Expires: exp}, nil
 }
 
 // Deprecated
 func (c *Context) NewAnonymousToken() error {
 	var exp = time.Now().Add(time.Hour * 12).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	signed, err := signingKeys.sign(jwt.MapClaims{
 		"aud": "api",
 		"nbf": time.Now().Add(-time.Minute).Unix(),
 		"exp": exp,
 		"iat": time.Now().Unix(),
 		"iss": "sdk",
 	})
-
-	signed, err := token.SignedString(signingKey)
 	if err != nil {
 		return err
 	}
 
-	c.Token = Token{signed, exp}
+	c.Token = Token{ID: signed, Expires: exp}
 	return nil
 }
 
@@ -114,7 +114,8 @@ func IssueClientToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = ctx.NewUserToken(holder.Id, APIClientRole)
+	// clients get no refresh token; they issue new tokens with their secret
+	ctx.Token, err = newToken(holder.Id, APIClientRole)
 	if err != nil {
 		ctx.PrintError(w, err, http.StatusUnauthorized)
 		return
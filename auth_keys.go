@@ -0,0 +1,301 @@
+package sdk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// JWT signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	ErrKeyNotValid   string = "key '%s' is not a valid %s key"
+	ErrKeyIdTaken    string = "key id '%s' is used more than once"
+	ErrKeyIdNotFound string = "token key id '%s' is not known"
+)
+
+var (
+	ErrNoSigningKey     = errors.New("key set has no signing key")
+	ErrKeyAlgMismatch   = errors.New("token algorithm doesn't match key algorithm")
+	ErrPEMNotValid      = errors.New("pem block is not a supported key")
+	ErrEdDSAKeyNotValid = errors.New("key is not a valid ed25519 key")
+)
+
+// JWTKey is a key of KeySet
+type JWTKey struct {
+	ID         string      // kid header of tokens signed with the key
+	Algorithm  string      // HS256, RS256, ES256 or EdDSA
+	Key        interface{} // []byte, *rsa.PrivateKey, *ecdsa.PrivateKey (P-256) or ed25519.PrivateKey
+	VerifyOnly bool        // key only verifies tokens; verify-only keys can be public keys
+}
+
+// KeySet holds keys that verify tokens by kid header. The first key that is not VerifyOnly signs new tokens;
+// to rotate keys put the new key first and keep the old one as VerifyOnly until its tokens expire.
+type KeySet []*JWTKey
+
+// signingKeys are keys of the app
+var signingKeys KeySet
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys; jwt-go doesn't implement it
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA, func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return EdDSA
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return ErrEdDSAKeyNotValid
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", ErrEdDSAKeyNotValid
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (k *JWTKey) method() jwt.SigningMethod {
+	if k.Algorithm == EdDSA {
+		return SigningMethodEdDSA
+	}
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// publicKey returns key that verifies tokens; for HS256 it is the secret
+func (k *JWTKey) publicKey() interface{} {
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+	return k.Key
+}
+
+func (k *JWTKey) valid() bool {
+	var private bool
+	switch k.Algorithm {
+	case HS256:
+		key, ok := k.Key.([]byte)
+		return ok && len(key) != 0
+	case RS256:
+		_, private = k.Key.(*rsa.PrivateKey)
+		if _, ok := k.Key.(*rsa.PublicKey); !ok && !private {
+			return false
+		}
+	case ES256:
+		var curve elliptic.Curve
+		switch key := k.Key.(type) {
+		case *ecdsa.PrivateKey:
+			curve, private = key.Curve, true
+		case *ecdsa.PublicKey:
+			curve = key.Curve
+		default:
+			return false
+		}
+		if curve != elliptic.P256() {
+			return false
+		}
+	case EdDSA:
+		switch key := k.Key.(type) {
+		case ed25519.PrivateKey:
+			private = len(key) == ed25519.PrivateKeySize
+			if !private {
+				return false
+			}
+		case ed25519.PublicKey:
+			if len(key) != ed25519.PublicKeySize {
+				return false
+			}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return private || k.VerifyOnly
+}
+
+// validate checks keys and returns signing key
+func (ks KeySet) validate() (*JWTKey, error) {
+	var signing *JWTKey
+	var ids = map[string]bool{}
+	for _, k := range ks {
+		if !k.valid() {
+			return nil, fmt.Errorf(ErrKeyNotValid, k.ID, k.Algorithm)
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf(ErrKeyIdTaken, k.ID)
+		}
+		ids[k.ID] = true
+		if signing == nil && !k.VerifyOnly {
+			signing = k
+		}
+	}
+	if signing == nil {
+		return nil, ErrNoSigningKey
+	}
+	return signing, nil
+}
+
+// sign returns token with claims signed by the signing key
+func (ks KeySet) sign(claims jwt.MapClaims) (string, error) {
+	k, err := ks.validate()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(k.method(), claims)
+	if len(k.ID) != 0 {
+		token.Header["kid"] = k.ID
+	}
+	return token.SignedString(k.Key)
+}
+
+// validationKey is jwt.Keyfunc that returns key of token kid header; tokens without kid are verified with the
+// key without ID. Token algorithm must be the key algorithm.
+func (ks KeySet) validationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range ks {
+		if k.ID != kid {
+			continue
+		}
+		if token.Method == nil || token.Method.Alg() != k.Algorithm {
+			return nil, ErrKeyAlgMismatch
+		}
+		return k.publicKey(), nil
+	}
+	return nil, fmt.Errorf(ErrKeyIdNotFound, kid)
+}
+
+// JWKS returns JSON Web Key Set of public keys; HS256 keys are secret and not included
+func (ks KeySet) JWKS() map[string]interface{} {
+	var keys = []map[string]interface{}{}
+	for _, k := range ks {
+		jwk := map[string]interface{}{
+			"kid": k.ID,
+			"alg": k.Algorithm,
+			"use": "sig",
+		}
+		switch key := k.publicKey().(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk["kty"] = "EC"
+			jwk["crv"] = "P-256"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(padded(key.X.Bytes(), 32))
+			jwk["y"] = base64.RawURLEncoding.EncodeToString(padded(key.Y.Bytes(), 32))
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(key)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// KeyFromPEM returns key of PEM encoded PKCS #1, PKCS #8, SEC 1 or PKIX key. Algorithm is the key type
+// algorithm; public keys are verify-only.
+func KeyFromPEM(id string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrPEMNotValid
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, ErrPEMNotValid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &JWTKey{ID: id, Key: key}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		k.Algorithm = RS256
+	case *rsa.PublicKey:
+		k.Algorithm, k.VerifyOnly = RS256, true
+	case *ecdsa.PrivateKey:
+		k.Algorithm = ES256
+	case *ecdsa.PublicKey:
+		k.Algorithm, k.VerifyOnly = ES256, true
+	case ed25519.PrivateKey:
+		k.Algorithm = EdDSA
+	case ed25519.PublicKey:
+		k.Algorithm, k.VerifyOnly = EdDSA, true
+	default:
+		return nil, ErrPEMNotValid
+	}
+	if !k.valid() {
+		return nil, fmt.Errorf(ErrKeyNotValid, k.ID, k.Algorithm)
+	}
+	return k, nil
+}
+
+// JWKSHandler publishes public keys of the app
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(signingKeys.JWKS())
+}
@@ -0,0 +1,229 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/mail"
+)
+
+// PasswordResetTTL is how long password reset links work
+var PasswordResetTTL = time.Hour
+
+var (
+	ErrPasswordResetNotValid      = errors.New("password reset token is not valid or has expired")
+	ErrPasswordResetNotConfigured = errors.New("password reset url is not set")
+	ErrPasswordNotValid           = errors.New("password is not valid")
+	ErrEmailNotValid              = errors.New("email is not valid")
+)
+
+// passwordResetTemplate is executed with Link and Expires; AppOptions.PasswordResetTemplate replaces it
+var passwordResetTemplate = template.Must(template.New("email").Parse(
+	`<p>To reset your password open <a href="{{.Link}}">{{.Link}}</a>. The link expires in {{.Expires}}.</p>` +
+		`<p>If you didn't ask to reset your password, ignore this email.</p>`))
+
+func passwordResetKey(c context.Context, token string) *datastore.Key {
+	sum := sha256.Sum256([]byte(token))
+	return datastore.NewKey(c, lostPasswordRequest.Name, hex.EncodeToString(sum[:]), 0, nil)
+}
+
+// sendPasswordReset stores reset request of user with email and emails the reset link. It runs in a task so that
+// requests of unknown emails take as long as the others.
+var sendPasswordReset = delay.Func("sdk.sendPasswordReset", func(c context.Context, email string, resetURL string, sender string) error {
+	userKey := datastore.NewKey(c, userEntity.Name, email, 0, nil)
+	user, err := userEntity.stored(c, userKey)
+	if err == datastore.ErrNoSuchEntity || (err == nil && user.isDeleted()) {
+		logInfof(c, "password reset of unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	var f = lostPasswordRequest.fields
+	var h = &EntityDataHolder{Entity: lostPasswordRequest, data: Data{
+		f["email"]:      email,
+		f["user"]:       userKey.Encode(),
+		f["isUnused"]:   true,
+		f["expiresAt"]:  time.Now().Add(PasswordResetTTL),
+		f["_createdAt"]: time.Now(),
+	}}
+	if _, err = store.Put(c, passwordResetKey(c, token), h); err != nil {
+		return err
+	}
+
+	link := resetURL + "?token=" + url.QueryEscape(token)
+	if strings.Contains(resetURL, "?") {
+		link = resetURL + "&token=" + url.QueryEscape(token)
+	}
+	ctx := Context{Context: c}
+	return ctx.SendEmail(&mail.Message{
+		Sender:  sender,
+		To:      []string{email},
+		Subject: "Reset your password",
+	}, passwordResetTemplate, map[string]interface{}{
+		"Link":    link,
+		"Expires": PasswordResetTTL.String(),
+	})
+})
+
+// ForgotPasswordHandler emails password reset link to email in input. The response is the same whether the
+// account exists or not.
+func ForgotPasswordHandler(a *SDK) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+
+		if len(a.PasswordResetURL) == 0 {
+			ctx.PrintError(w, ErrPasswordResetNotConfigured, http.StatusInternalServerError)
+			return
+		}
+
+		formHolder, _ := emptyEntity.FromForm(ctx)
+		email, _ := formHolder.GetInput("email").(string)
+		if !govalidator.IsEmail(email) {
+			ctx.PrintError(w, ErrEmailNotValid, http.StatusBadRequest)
+			return
+		}
+
+		if err := sendPasswordReset.Call(ctx.Context, email, a.PasswordResetURL, a.AdminEmail); err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Print(w, "ok")
+	}
+}
+
+// ResetPassword sets new password of user with reset token and revokes sessions of the user. Tokens can be used
+// once; after the password is set all unused reset tokens of the user stop working.
+func (c *Context) ResetPassword(token string, password string) error {
+	if !userEntity.fields["password"].Validator(password) {
+		return ErrPasswordNotValid
+	}
+	hash, err := FuncHashTransform(nil, password)
+	if err != nil {
+		return err
+	}
+
+	// token is used in the transaction that sets the password
+	var f = lostPasswordRequest.fields
+	var ctx = c.WithScopes(ScopeEdit)
+	var encodedUserKey string
+	var user *EntityDataHolder
+	err = store.RunInTransaction(c.Context, func(tc context.Context) error {
+		key := passwordResetKey(tc, token)
+		h, err := lostPasswordRequest.stored(tc, key)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrPasswordResetNotValid
+		}
+		if err != nil {
+			return err
+		}
+		if unused, _ := h.data[f["isUnused"]].(bool); !unused {
+			return ErrPasswordResetNotValid
+		}
+		if exp, _ := h.data[f["expiresAt"]].(time.Time); time.Now().After(exp) {
+			return ErrPasswordResetNotValid
+		}
+		encodedUserKey, _ = h.data[f["user"]].(string)
+		userKey, err := datastore.DecodeKey(encodedUserKey)
+		if err != nil {
+			return ErrPasswordResetNotValid
+		}
+
+		h.data[f["isUnused"]] = false
+		if _, err = store.Put(tc, key, h); err != nil {
+			return err
+		}
+
+		user, _, err = userEntity.editIn(tc, ctx, userKey, RevisionEdit, func() *EntityDataHolder {
+			return &EntityDataHolder{Entity: userEntity, data: Data{}}
+		}, func(h *EntityDataHolder) error {
+			h.data[userEntity.fields["password"]] = hash
+			return nil
+		})
+		if err == datastore.ErrNoSuchEntity {
+			return ErrPasswordResetNotValid
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	userEntity.dispatchLater(ctx)
+	if err = userEntity.hook(HookAfterWrite, ctx, user); err != nil {
+		return err
+	}
+
+	if err = expirePasswordResets(c.Context, encodedUserKey); err != nil {
+		return err
+	}
+	return RevokeSessions(c.Context, encodedUserKey)
+}
+
+// expirePasswordResets marks unused reset tokens of user as used
+func expirePasswordResets(c context.Context, userKey string) error {
+	var f = lostPasswordRequest.fields
+	t := store.Run(c, &StoreQuery{
+		Kind: lostPasswordRequest.Name,
+		Filters: []EntityQueryFilter{
+			{Name: "user", Operator: "=", Value: userKey},
+			{Name: "isUnused", Operator: "=", Value: true},
+		},
+	})
+	var keys []*datastore.Key
+	var src []datastore.PropertyLoadSaver
+	for {
+		h := &EntityDataHolder{Entity: lostPasswordRequest, data: Data{}}
+		k, err := t.Next(h)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		h.data[f["isUnused"]] = false
+		keys = append(keys, k)
+		src = append(src, h)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := store.PutMulti(c, keys, src)
+	return err
+}
+
+// ResetPasswordHandler sets password with token from reset email; all invalid tokens get the same response
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	token, _ := formHolder.GetInput("token").(string)
+	password, _ := formHolder.GetInput("password").(string)
+
+	err := ctx.ResetPassword(token, password)
+	if err == ErrPasswordResetNotValid || err == ErrPasswordNotValid {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
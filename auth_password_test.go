@@ -0,0 +1,98 @@
+package sdk
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+var authEntitiesOnce sync.Once
+
+// addTestUser stores user with email and role; auth entities are initialized as by EnableAuthAPI
+func addTestUser(t *testing.T, ctx Context, email string, role Role) *datastore.Key {
+	authEntitiesOnce.Do(func() {
+		for _, e := range []*Entity{lostPasswordRequest, userEntity, refreshTokenEntity, twoFactorEntity} {
+			if _, err := e.init(); err != nil {
+				panic(err)
+			}
+		}
+	})
+	f := userEntity.fields
+	key := datastore.NewKey(ctx.Context, userEntity.Name, email, 0, nil)
+	h := &EntityDataHolder{Entity: userEntity, data: Data{
+		f["email"]:    email,
+		f["role"]:     string(role),
+		f["password"]: []byte("not a hash"),
+	}}
+	if _, err := store.Put(ctx.Context, key, h); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func addTestPasswordReset(t *testing.T, ctx Context, userKey *datastore.Key, token string) {
+	f := lostPasswordRequest.fields
+	h := &EntityDataHolder{Entity: lostPasswordRequest, data: Data{
+		f["email"]:      userKey.StringID(),
+		f["user"]:       userKey.Encode(),
+		f["isUnused"]:   true,
+		f["expiresAt"]:  time.Now().Add(PasswordResetTTL),
+		f["_createdAt"]: time.Now(),
+	}}
+	if _, err := store.Put(ctx.Context, passwordResetKey(ctx.Context, token), h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	userKey := addTestUser(t, ctx, "reset@example.com", "user")
+	addTestPasswordReset(t, ctx, userKey, "first")
+	addTestPasswordReset(t, ctx, userKey, "second")
+
+	if err := ctx.ResetPassword("first", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := userEntity.stored(ctx.Context, userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := user.data[userEntity.fields["password"]].([]byte)
+	if decrypt(hash, []byte("new password")) != nil {
+		t.Fatal("password is not set")
+	}
+
+	if err = ctx.ResetPassword("first", "other password"); err != ErrPasswordResetNotValid {
+		t.Fatalf("reset with used token returned %v, want ErrPasswordResetNotValid", err)
+	}
+	if err = ctx.ResetPassword("second", "other password"); err != ErrPasswordResetNotValid {
+		t.Fatalf("reset with other token of user returned %v, want ErrPasswordResetNotValid", err)
+	}
+	if err = ctx.ResetPassword("unknown", "other password"); err != ErrPasswordResetNotValid {
+		t.Fatalf("reset with unknown token returned %v, want ErrPasswordResetNotValid", err)
+	}
+}
+
+func TestResetPasswordOfDeletedUser(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	userKey := addTestUser(t, ctx, "gone@example.com", "user")
+	addTestPasswordReset(t, ctx, userKey, "token")
+	if err := store.Delete(ctx.Context, userKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctx.ResetPassword("token", "new password"); err != ErrPasswordResetNotValid {
+		t.Fatalf("reset of deleted user returned %v, want ErrPasswordResetNotValid", err)
+	}
+
+	// token is not used by the failed reset
+	h, err := lostPasswordRequest.stored(ctx.Context, passwordResetKey(ctx.Context, "token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unused, _ := h.data[lostPasswordRequest.fields["isUnused"]].(bool); !unused {
+		t.Fatal("token is used by failed reset")
+	}
+}
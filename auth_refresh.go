@@ -0,0 +1,291 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	AccessTokenTTL  = 15 * time.Minute    // lifetime of access tokens
+	RefreshTokenTTL = 30 * 24 * time.Hour // lifetime of refresh tokens; every refresh issues a new one
+)
+
+var (
+	ErrRefreshTokenNotValid = errors.New("refresh token is not valid")
+	ErrRefreshTokenReused   = errors.New("refresh token was already used; session is revoked")
+)
+
+// refreshTokenEntity stores refresh tokens by hash. Tokens issued by refreshing belong to the family of the
+// login that started the session; secondFactor is set if the login passed two-factor authentication.
+var refreshTokenEntity = &Entity{
+	Name: "_refreshToken",
+	Fields: []*Field{
+		{Name: "user"},
+		{Name: "role", NoIndex: true},
+		{Name: "family"},
+		{Name: "used", Type: BoolType},
+		{Name: "secondFactor", Type: BoolType},
+		{Name: "expiresAt", Type: DateTimeType},
+	},
+}
+
+func refreshTokenKey(c context.Context, token string) *datastore.Key {
+	sum := sha256.Sum256([]byte(token))
+	return datastore.NewKey(c, refreshTokenEntity.Name, hex.EncodeToString(sum[:]), 0, nil)
+}
+
+// newRefreshToken stores new refresh token of session family
+func newRefreshToken(c context.Context, userKey string, userRole Role, family string, secondFactor bool) (string, int64, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", 0, err
+	}
+	var exp = time.Now().Add(RefreshTokenTTL)
+
+	var f = refreshTokenEntity.fields
+	var h = &EntityDataHolder{Entity: refreshTokenEntity, data: Data{
+		f["user"]:         userKey,
+		f["role"]:         string(userRole),
+		f["family"]:       family,
+		f["used"]:         false,
+		f["secondFactor"]: secondFactor,
+		f["expiresAt"]:    exp,
+		f["_createdAt"]:   time.Now(),
+	}}
+	if _, err := store.Put(c, refreshTokenKey(c, token), h); err != nil {
+		return "", 0, err
+	}
+	return token, exp.Unix(), nil
+}
+
+// newSessionToken returns access token and refresh token of session family
+func newSessionToken(c context.Context, userKey string, userRole Role, family string, secondFactor bool) (Token, error) {
+	tkn, err := newToken(userKey, userRole)
+	if err != nil {
+		return tkn, err
+	}
+	tkn.Refresh, tkn.RefreshExpires, err = newRefreshToken(c, userKey, userRole, family, secondFactor)
+	return tkn, err
+}
+
+// sessionRole returns current role of session user with the rules of login. Sessions of deleted users and
+// sessions that didn't pass two-factor authentication the user now needs are not valid.
+func sessionRole(c context.Context, userKey string, secondFactor bool) (Role, error) {
+	key, err := datastore.DecodeKey(userKey)
+	if err != nil {
+		return "", ErrRefreshTokenNotValid
+	}
+	user, err := userEntity.stored(c, key)
+	if err == datastore.ErrNoSuchEntity || (err == nil && user.isDeleted()) {
+		return "", ErrRefreshTokenNotValid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	role, _ := user.data[userEntity.fields["role"]].(string)
+	userRole := Role(role)
+	if verifyEmail && !emailVerified(user) {
+		if len(unverifiedRole) == 0 {
+			return "", ErrEmailNotVerified
+		}
+		userRole = unverifiedRole
+	}
+
+	if !secondFactor {
+		enabled, err := twoFactorEnabled(c, userKey)
+		if err != nil {
+			return "", err
+		}
+		if enabled || twoFactorRoles[userRole] {
+			return "", ErrRefreshTokenNotValid
+		}
+	}
+	return userRole, nil
+}
+
+// Refresh exchanges refresh token for new access and refresh tokens. Every refresh token can be used once;
+// using it again revokes all tokens of its session as the token was probably stolen. New tokens have the
+// current role of the user.
+func (c *Context) Refresh(refreshToken string) error {
+	var f = refreshTokenEntity.fields
+	var key = refreshTokenKey(c.Context, refreshToken)
+
+	var userKey, family string
+	var userRole Role
+	var reused bool
+	err := store.RunInTransaction(c.Context, func(tc context.Context) error {
+		reused = false
+		h, err := refreshTokenEntity.stored(tc, key)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrRefreshTokenNotValid
+		}
+		if err != nil {
+			return err
+		}
+		userKey, _ = h.data[f["user"]].(string)
+		family, _ = h.data[f["family"]].(string)
+		secondFactor, _ := h.data[f["secondFactor"]].(bool)
+
+		if used, _ := h.data[f["used"]].(bool); used {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+		if exp, _ := h.data[f["expiresAt"]].(time.Time); time.Now().After(exp) {
+			return ErrRefreshTokenNotValid
+		}
+
+		if userRole, err = sessionRole(tc, userKey, secondFactor); err != nil {
+			return err
+		}
+
+		h.data[f["used"]] = true
+		if _, err := store.Put(tc, key, h); err != nil {
+			return err
+		}
+		c.Token, err = newSessionToken(tc, userKey, userRole, family, secondFactor)
+		return err
+	})
+	if reused {
+		if rerr := revokeRefreshTokens(c.Context, "family", family); rerr != nil {
+			return rerr
+		}
+	}
+	if err != nil {
+		c.Token = Token{}
+		return err
+	}
+
+	c.User = userKey
+	c.Role = userRole
+	c.IsAuthenticated = true
+	return nil
+}
+
+// Logout revokes all tokens of refresh token session
+func (c *Context) Logout(refreshToken string) error {
+	h, err := refreshTokenEntity.stored(c.Context, refreshTokenKey(c.Context, refreshToken))
+	if err == datastore.ErrNoSuchEntity {
+		return ErrRefreshTokenNotValid
+	}
+	if err != nil {
+		return err
+	}
+	family, _ := h.data[refreshTokenEntity.fields["family"]].(string)
+	return revokeRefreshTokens(c.Context, "family", family)
+}
+
+// RevokeSessions revokes refresh tokens of all sessions of user; access tokens expire within AccessTokenTTL
+func RevokeSessions(c context.Context, userKey string) error {
+	return revokeRefreshTokens(c, "user", userKey)
+}
+
+// revokeRefreshTokens deletes refresh tokens with field value
+func revokeRefreshTokens(c context.Context, field string, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	t := store.Run(c, &StoreQuery{
+		Kind:    refreshTokenEntity.Name,
+		Filters: []EntityQueryFilter{{Name: field, Operator: "=", Value: value}},
+	})
+	var keys []*datastore.Key
+	for {
+		k, err := t.Next(&EntityDataHolder{Entity: refreshTokenEntity, data: Data{}})
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return store.DeleteMulti(c, keys)
+}
+
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	refreshToken, _ := formHolder.GetInput("refreshToken").(string)
+	if len(refreshToken) == 0 {
+		ctx.PrintError(w, ErrRefreshTokenNotValid, http.StatusBadRequest)
+		return
+	}
+
+	err := ctx.Refresh(refreshToken)
+	if err == ErrRefreshTokenNotValid || err == ErrRefreshTokenReused {
+		ctx.PrintError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err == ErrEmailNotVerified {
+		ctx.PrintError(w, err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
+
+// LogoutHandler revokes session of refresh token; with all=true authenticated user is logged out of all sessions
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	var err error
+	if r.URL.Query().Get("all") == "true" {
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, ErrNotAuthenticated, http.StatusUnauthorized)
+			return
+		}
+		err = RevokeSessions(ctx.Context, ctx.User)
+	} else {
+		formHolder, _ := emptyEntity.FromForm(ctx)
+		refreshToken, _ := formHolder.GetInput("refreshToken").(string)
+		err = ctx.Logout(refreshToken)
+	}
+	if err == ErrRefreshTokenNotValid {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
+
+// RevokeUserSessionsHandler revokes all sessions of user in path; only admins can use it
+func RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+	if ctx.Role != AdminRole {
+		ctx.PrintError(w, ErrNotAuthorized, http.StatusForbidden)
+		return
+	}
+
+	userKey := mux.Vars(r)["encodedKey"]
+	if key, err := datastore.DecodeKey(userKey); err != nil || key.Kind() != userEntity.Name {
+		ctx.PrintError(w, datastore.ErrInvalidKey, http.StatusBadRequest)
+		return
+	}
+
+	if err := RevokeSessions(ctx.Context, userKey); err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
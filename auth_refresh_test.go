@@ -0,0 +1,44 @@
+package sdk
+
+import "testing"
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	signingKeys = KeySet{{Algorithm: HS256, Key: []byte("test")}}
+	userKey := addTestUser(t, ctx, "refresh@example.com", "user").Encode()
+
+	if err := ctx.NewUserToken(userKey, "user"); err != nil {
+		t.Fatal(err)
+	}
+	first := ctx.Token.Refresh
+	if err := ctx.Refresh(first); err != nil {
+		t.Fatal(err)
+	}
+	second := ctx.Token.Refresh
+	if second == first {
+		t.Fatal("refresh token isn't rotated")
+	}
+
+	// used token revokes its session
+	if err := ctx.Refresh(first); err != ErrRefreshTokenReused {
+		t.Fatalf("refresh with used token returned %v, want ErrRefreshTokenReused", err)
+	}
+	if err := ctx.Refresh(second); err != ErrRefreshTokenNotValid {
+		t.Fatalf("refresh after reuse returned %v, want ErrRefreshTokenNotValid", err)
+	}
+
+	// other sessions are kept
+	if err := ctx.NewUserToken(userKey, "user"); err != nil {
+		t.Fatal(err)
+	}
+	other := ctx.Token.Refresh
+	if err := ctx.NewUserToken(userKey, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Logout(ctx.Token.Refresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Refresh(other); err != nil {
+		t.Fatalf("refresh of other session after logout returned %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package sdk
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestAuthMiddlewareSigningKey(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(m *JWTMiddleware) error {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return m.CheckJWT(httptest.NewRecorder(), r)
+	}
+
+	if err = check(AuthMiddleware([]byte("secret"))); err != nil {
+		t.Fatalf("token signed with signing key is rejected: %v", err)
+	}
+	if err = check(AuthMiddleware([]byte("other"))); err == nil {
+		t.Fatal("token signed with other key is accepted")
+	}
+	if err = check(AuthMiddlewareWithKeys(KeySet{{ID: "k1", Algorithm: HS256, Key: []byte("secret")}})); err == nil {
+		t.Fatal("token without kid is accepted by key with ID")
+	}
+}
@@ -0,0 +1,525 @@
+package sdk
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// RFC 6238 parameters of authenticator apps
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // codes of neighbouring periods are accepted for clock drift
+)
+
+var (
+	MFATokenTTL          = 5 * time.Minute  // lifetime of tokens between password and code
+	MaxTwoFactorAttempts = 5                // failed codes before two-factor login is locked
+	TwoFactorLockout     = 15 * time.Minute // how long two-factor login stays locked
+	RecoveryCodeCount    = 10               // recovery codes issued when two-factor authentication is enabled
+)
+
+const mfaAudience = "mfa"
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp    = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorCodeInvalid = errors.New("two-factor code is not valid")
+	ErrTwoFactorLocked      = errors.New("too many failed two-factor codes; try again later")
+	ErrMFATokenNotValid     = errors.New("mfa token is not valid, has expired or was used")
+)
+
+// set by EnableAuthAPI from AppOptions
+var (
+	twoFactorRoles  map[Role]bool
+	twoFactorIssuer string
+)
+
+// twoFactorEntity holds TOTP secret of user by encoded user key. Secret is enabled once a code of it is
+// confirmed; recovery codes are stored as hashes and removed when used. Ids of mfa tokens that started a
+// session are kept until the tokens expire.
+var twoFactorEntity = &Entity{
+	Name: "_twoFactor",
+	Fields: []*Field{
+		{Name: "secret", NoIndex: true, Json: NoJsonOutput},
+		{Name: "enabled", Type: BoolType},
+		{Name: "recoveryCodes", Multiple: true, NoIndex: true, Json: NoJsonOutput},
+		{Name: "lastStep", Type: IntType, NoIndex: true},
+		{Name: "failures", Type: IntType, NoIndex: true},
+		{Name: "lockedUntil", Type: DateTimeType, NoIndex: true},
+		{Name: "usedTokens", Multiple: true, NoIndex: true, Json: NoJsonOutput},
+	},
+}
+
+func twoFactorKey(c context.Context, userKey string) *datastore.Key {
+	return datastore.NewKey(c, twoFactorEntity.Name, userKey, 0, nil)
+}
+
+// totpCode returns code of secret in time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpStep returns time step of code if code is valid and newer than lastStep; codes can be used once
+func totpStep(secret string, code string, lastStep int64, t time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns codes and their hashes
+func newRecoveryCodes() ([]string, []interface{}, error) {
+	var codes []string
+	var hashes []interface{}
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// twoFactorEnabled returns true if user confirmed TOTP setup
+func twoFactorEnabled(c context.Context, userKey string) (bool, error) {
+	h, err := twoFactorEntity.stored(c, twoFactorKey(c, userKey))
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	enabled, _ := h.data[twoFactorEntity.fields["enabled"]].(bool)
+	return enabled, nil
+}
+
+// mfaToken holds claims of token that only two-factor routes accept
+type mfaToken struct {
+	id      string
+	user    string
+	role    Role
+	enroll  bool // user must set up two-factor authentication first
+	expires int64
+}
+
+// newMFAToken returns token that only two-factor routes accept; enroll is set for users that must set up
+// two-factor authentication first
+func newMFAToken(userKey string, userRole Role, enroll bool) (string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	return signingKeys.sign(jwt.MapClaims{
+		"aud":    mfaAudience,
+		"exp":    time.Now().Add(MFATokenTTL).Unix(),
+		"iat":    time.Now().Unix(),
+		"iss":    "sdk",
+		"jti":    id,
+		"sub":    userKey,
+		"rol":    userRole,
+		"enroll": enroll,
+	})
+}
+
+func parseMFAToken(token string) (*mfaToken, error) {
+	parsed, err := new(jwt.Parser).Parse(token, signingKeys.validationKey)
+	if err != nil || !parsed.Valid {
+		return nil, ErrMFATokenNotValid
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(mfaAudience, true) {
+		return nil, ErrMFATokenNotValid
+	}
+	var t = &mfaToken{}
+	t.id, _ = claims["jti"].(string)
+	t.user, _ = claims["sub"].(string)
+	role, _ := claims["rol"].(string)
+	t.role = Role(role)
+	t.enroll, _ = claims["enroll"].(bool)
+	exp, _ := claims["exp"].(float64)
+	t.expires = int64(exp)
+	if len(t.id) == 0 || len(t.user) == 0 || len(t.role) == 0 {
+		return nil, ErrMFATokenNotValid
+	}
+	return t, nil
+}
+
+// useMFAToken records token t as used in two-factor entity h; tokens that expired are forgotten
+func useMFAToken(h *EntityDataHolder, t *mfaToken) error {
+	var f = twoFactorEntity.fields
+	var now = time.Now().Unix()
+	var used = []interface{}{}
+	stored, _ := h.data[f["usedTokens"]].([]interface{})
+	for _, v := range stored {
+		s, _ := v.(string)
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[1] == t.id {
+			return ErrMFATokenNotValid
+		}
+		if exp, err := strconv.ParseInt(parts[0], 10, 64); err == nil && exp >= now {
+			used = append(used, v)
+		}
+	}
+	h.data[f["usedTokens"]] = append(used, fmt.Sprintf("%d:%s", t.expires, t.id))
+	return nil
+}
+
+// loginSecondFactor prints mfa token instead of user token if user has two-factor authentication or its role
+// requires it; returns false if login can continue
+func (c *Context) loginSecondFactor(w http.ResponseWriter, userKey string, userRole Role) bool {
+	enabled, err := twoFactorEnabled(c.Context, userKey)
+	if err != nil {
+		c.PrintError(w, err, http.StatusInternalServerError)
+		return true
+	}
+	if !enabled && !twoFactorRoles[userRole] {
+		return false
+	}
+
+	token, err := newMFAToken(userKey, userRole, !enabled)
+	if err != nil {
+		c.PrintError(w, err, http.StatusInternalServerError)
+		return true
+	}
+	c.Print(w, map[string]interface{}{
+		"mfaRequired": true,
+		"mfaToken":    token,
+		"mfaEnroll":   !enabled, // user sets up two-factor authentication with the token
+	})
+	return true
+}
+
+// twoFactorUser returns authenticated user or user of enroll mfa token in input
+func twoFactorUser(ctx Context, mfaToken string) (userKey string, userRole Role, err error) {
+	if ctx.IsAuthenticated {
+		return ctx.User, ctx.Role, nil
+	}
+	if len(mfaToken) == 0 {
+		return "", "", ErrNotAuthenticated
+	}
+	t, err := parseMFAToken(mfaToken)
+	if err != nil {
+		return "", "", err
+	}
+	if !t.enroll {
+		return "", "", ErrMFATokenNotValid
+	}
+	return t.user, t.role, nil
+}
+
+// SetupTwoFactor stores new TOTP secret of user and returns it with otpauth URI for authenticator apps. The
+// secret is enabled by ConfirmTwoFactor.
+func (c *Context) SetupTwoFactor(userKey string) (secret string, uri string, err error) {
+	key, err := datastore.DecodeKey(userKey)
+	if err != nil {
+		return "", "", err
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	var f = twoFactorEntity.fields
+	err = store.RunInTransaction(c.Context, func(tc context.Context) error {
+		tfKey := twoFactorKey(tc, userKey)
+		h, err := twoFactorEntity.stored(tc, tfKey)
+		if err == nil {
+			if enabled, _ := h.data[f["enabled"]].(bool); enabled {
+				return ErrTwoFactorEnabled
+			}
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		h = &EntityDataHolder{Entity: twoFactorEntity, data: Data{
+			f["secret"]:     secret,
+			f["enabled"]:    false,
+			f["lastStep"]:   int64(0),
+			f["failures"]:   int64(0),
+			f["_createdAt"]: time.Now(),
+		}}
+		_, err = store.Put(tc, tfKey, h)
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	issuer := twoFactorIssuer
+	if len(issuer) == 0 {
+		issuer = appengine.AppID(c.Context)
+	}
+	label := url.PathEscape(issuer + ":" + key.StringID())
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return secret, "otpauth://totp/" + label + "?" + query.Encode(), nil
+}
+
+// ConfirmTwoFactor enables TOTP secret of user with its code and returns recovery codes; they are shown once
+func (c *Context) ConfirmTwoFactor(userKey string, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var f = twoFactorEntity.fields
+	err = store.RunInTransaction(c.Context, func(tc context.Context) error {
+		tfKey := twoFactorKey(tc, userKey)
+		h, err := twoFactorEntity.stored(tc, tfKey)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrTwoFactorNotSetUp
+		}
+		if err != nil {
+			return err
+		}
+		if enabled, _ := h.data[f["enabled"]].(bool); enabled {
+			return ErrTwoFactorEnabled
+		}
+
+		secret, _ := h.data[f["secret"]].(string)
+		step, ok := totpStep(secret, code, 0, time.Now())
+		if !ok {
+			return ErrTwoFactorCodeInvalid
+		}
+		h.data[f["enabled"]] = true
+		h.data[f["lastStep"]] = step
+		h.data[f["recoveryCodes"]] = hashes
+		_, err = store.Put(tc, tfKey, h)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTwoFactor checks TOTP code or recovery code of user. Codes and recovery codes can be used once; too many
+// failed codes lock two-factor login for TwoFactorLockout.
+func (c *Context) VerifyTwoFactor(userKey string, code string, recoveryCode string) error {
+	return c.verifyTwoFactor(userKey, nil, code, recoveryCode)
+}
+
+// verifyTwoFactor is VerifyTwoFactor that also uses mfa token t if it is set; each token starts one session
+func (c *Context) verifyTwoFactor(userKey string, t *mfaToken, code string, recoveryCode string) error {
+	var f = twoFactorEntity.fields
+	var valid bool
+	err := store.RunInTransaction(c.Context, func(tc context.Context) error {
+		valid = false
+		tfKey := twoFactorKey(tc, userKey)
+		h, err := twoFactorEntity.stored(tc, tfKey)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrTwoFactorNotSetUp
+		}
+		if err != nil {
+			return err
+		}
+		if enabled, _ := h.data[f["enabled"]].(bool); !enabled {
+			return ErrTwoFactorNotSetUp
+		}
+		if lockedUntil, _ := h.data[f["lockedUntil"]].(time.Time); time.Now().Before(lockedUntil) {
+			return ErrTwoFactorLocked
+		}
+
+		if len(recoveryCode) != 0 {
+			hash := hashRecoveryCode(recoveryCode)
+			stored, _ := h.data[f["recoveryCodes"]].([]interface{})
+			var left = []interface{}{}
+			for _, v := range stored {
+				if s, _ := v.(string); !valid && hmac.Equal([]byte(s), []byte(hash)) {
+					valid = true
+					continue
+				}
+				left = append(left, v)
+			}
+			if valid {
+				h.data[f["recoveryCodes"]] = left
+			}
+		} else {
+			secret, _ := h.data[f["secret"]].(string)
+			lastStep, _ := h.data[f["lastStep"]].(int64)
+			var step int64
+			if step, valid = totpStep(secret, code, lastStep, time.Now()); valid {
+				h.data[f["lastStep"]] = step
+			}
+		}
+
+		// failures are stored, so the transaction succeeds either way
+		if valid {
+			if t != nil {
+				if err := useMFAToken(h, t); err != nil {
+					return err
+				}
+			}
+			h.data[f["failures"]] = int64(0)
+		} else {
+			failures, _ := h.data[f["failures"]].(int64)
+			failures++
+			if failures >= int64(MaxTwoFactorAttempts) {
+				h.data[f["lockedUntil"]] = time.Now().Add(TwoFactorLockout)
+				failures = 0
+			}
+			h.data[f["failures"]] = failures
+		}
+		_, err = store.Put(tc, tfKey, h)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+// twoFactorStatus returns response status of two-factor errors
+func twoFactorStatus(err error) int {
+	switch err {
+	case ErrNotAuthenticated, ErrMFATokenNotValid, ErrTwoFactorCodeInvalid:
+		return http.StatusUnauthorized
+	case ErrTwoFactorEnabled:
+		return http.StatusConflict
+	case ErrTwoFactorNotSetUp:
+		return http.StatusBadRequest
+	case ErrTwoFactorLocked:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// SetupTwoFactorHandler starts TOTP setup of authenticated user or user with enroll mfa token
+func SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	mfaToken, _ := formHolder.GetInput("mfaToken").(string)
+	userKey, _, err := twoFactorUser(ctx, mfaToken)
+	if err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	secret, uri, err := ctx.SetupTwoFactor(userKey)
+	if err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	ctx.Print(w, map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+	})
+}
+
+// ConfirmTwoFactorHandler enables TOTP with code and prints recovery codes. The user gets a new session as
+// sessions started without two-factor authentication can't be refreshed anymore.
+func ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	mfaToken, _ := formHolder.GetInput("mfaToken").(string)
+	code, _ := formHolder.GetInput("code").(string)
+	userKey, userRole, err := twoFactorUser(ctx, mfaToken)
+	if err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	codes, err := ctx.ConfirmTwoFactor(userKey, code)
+	if err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	if err = ctx.newUserToken(userKey, userRole, true); err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, map[string]interface{}{
+		"recoveryCodes": codes,
+	})
+}
+
+// VerifyTwoFactorHandler exchanges mfa token and TOTP code or recovery code for user token
+func VerifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	mfaToken, _ := formHolder.GetInput("mfaToken").(string)
+	code, _ := formHolder.GetInput("code").(string)
+	recoveryCode, _ := formHolder.GetInput("recoveryCode").(string)
+
+	t, err := parseMFAToken(mfaToken)
+	if err == nil && t.enroll {
+		err = ErrTwoFactorNotSetUp
+	}
+	if err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	if err = ctx.verifyTwoFactor(t.user, t, code, recoveryCode); err != nil {
+		ctx.PrintError(w, err, twoFactorStatus(err))
+		return
+	}
+
+	if err = ctx.newUserToken(t.user, t.role, true); err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
@@ -0,0 +1,128 @@
+package sdk
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 secret of SHA-1 test vectors
+var totpTestSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B; codes are the last six digits
+	for _, v := range []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		if code := totpCode(totpTestSecret, v.time/totpPeriod); code != v.code {
+			t.Fatalf("code at %d is %s, want %s", v.time, code, v.code)
+		}
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(totpTestSecret)
+	step, ok := totpStep(secret, "081804", 0, time.Unix(1111111109, 0))
+	if !ok || step != 1111111109/totpPeriod {
+		t.Fatalf("step of valid code is %d and %v", step, ok)
+	}
+	if _, ok = totpStep(secret, "081804", 0, time.Unix(1111111109+totpPeriod, 0)); !ok {
+		t.Fatal("code of previous period isn't accepted")
+	}
+	if _, ok = totpStep(secret, "081804", 0, time.Unix(1111111109+3*totpPeriod, 0)); ok {
+		t.Fatal("expired code is accepted")
+	}
+	if _, ok = totpStep(secret, "081804", step, time.Unix(1111111109, 0)); ok {
+		t.Fatal("used code is accepted")
+	}
+}
+
+// addTestTwoFactor enables two-factor authentication of user and returns its secret and recovery codes
+func addTestTwoFactor(t *testing.T, ctx Context, email string) (string, []byte, []string) {
+	userKey := addTestUser(t, ctx, email, "user").Encode()
+	twoFactorIssuer = "test"
+	secret, _, err := ctx.SetupTwoFactor(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ctx.ConfirmTwoFactor(userKey, totpCode(key, time.Now().Unix()/totpPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userKey, key, codes
+}
+
+func TestVerifyTwoFactorLockout(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	userKey, key, _ := addTestTwoFactor(t, ctx, "lockout@example.com")
+
+	for i := 0; i < MaxTwoFactorAttempts; i++ {
+		if err := ctx.VerifyTwoFactor(userKey, "000000", ""); err != ErrTwoFactorCodeInvalid {
+			t.Fatalf("verify of wrong code returned %v, want ErrTwoFactorCodeInvalid", err)
+		}
+	}
+	next := totpCode(key, time.Now().Unix()/totpPeriod+1)
+	if err := ctx.VerifyTwoFactor(userKey, next, ""); err != ErrTwoFactorLocked {
+		t.Fatalf("verify after %d failures returned %v, want ErrTwoFactorLocked", MaxTwoFactorAttempts, err)
+	}
+}
+
+func TestVerifyTwoFactorRecoveryCode(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	userKey, _, codes := addTestTwoFactor(t, ctx, "recovery@example.com")
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("%d recovery codes are issued, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	// codes are accepted without dash and in upper case
+	code := strings.ToUpper(codes[0][:4] + codes[0][5:])
+	if err := ctx.VerifyTwoFactor(userKey, "", " "+code+" "); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.VerifyTwoFactor(userKey, "", codes[0]); err != ErrTwoFactorCodeInvalid {
+		t.Fatalf("verify of used recovery code returned %v, want ErrTwoFactorCodeInvalid", err)
+	}
+	if err := ctx.VerifyTwoFactor(userKey, "", codes[1]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMFATokenSingleUse(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	signingKeys = KeySet{{Algorithm: HS256, Key: []byte("test")}}
+	userKey, _, codes := addTestTwoFactor(t, ctx, "mfa@example.com")
+
+	token, err := newMFAToken(userKey, "user", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := parseMFAToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.verifyTwoFactor(userKey, mfa, "", codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.verifyTwoFactor(userKey, mfa, "", codes[1]); err != ErrMFATokenNotValid {
+		t.Fatalf("verify with used mfa token returned %v, want ErrMFATokenNotValid", err)
+	}
+
+	token, _ = newMFAToken(userKey, "user", false)
+	if mfa, err = parseMFAToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.verifyTwoFactor(userKey, mfa, "", codes[1]); err != nil {
+		t.Fatalf("verify with new mfa token returned %v", err)
+	}
+}
@@ -25,8 +25,16 @@ func init() {
 			},
 			{
 				Name:         "isUnused",
+				Type:         BoolType,
 				DefaultValue: true,
 			},
+			{
+				Name: "user",
+			},
+			{
+				Name: "expiresAt",
+				Type: DateTimeType,
+			},
 		},
 	}
 	userEntity = &Entity{
@@ -44,6 +52,10 @@ func init() {
 				Name:         "role",
 				DefaultValue: string(SubscriberRole),
 			},
+			{
+				Name: "emailVerified",
+				Type: BoolType,
+			},
 			{
 				Name:       "password",
 				IsRequired: true,
@@ -172,8 +184,8 @@ func EditUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h, err := ProfileEntity.FromForm(ctx)
-	if err != nil {
-		ctx.PrintError(w, err, http.StatusInternalServerError)
+	if err = partialInput(err); err != nil {
+		ctx.PrintError(w, err, errorStatus(err))
 		return
 	}
 
@@ -224,6 +236,20 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	role := Role(d.Get(ctx, "role").(string))
+	if verifyEmail && !emailVerified(d) {
+		if len(unverifiedRole) == 0 {
+			ctx.PrintError(w, ErrEmailNotVerified, http.StatusForbidden)
+			return
+		}
+		role = unverifiedRole
+	}
+
+	// user token is issued after second factor
+	if ctx.loginSecondFactor(w, d.Id, role) {
+		return
+	}
+
 	ctx, profileKey, err := ProfileEntity.NewKey(ctx, d.Id)
 	if err != nil {
 		ctx.PrintError(w, err, http.StatusInternalServerError)
@@ -241,7 +267,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		data[name] = value
 	}
 
-	err = ctx.NewUserToken(d.Id, Role(d.Get(ctx, "role").(string)))
+	err = ctx.NewUserToken(d.Id, role)
 	if err != nil {
 		ctx.PrintError(w, err, http.StatusInternalServerError)
 		return
@@ -277,6 +303,9 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// email is verified with link from verification email
+	d.data[userEntity.fields["emailVerified"]] = false
+
 	key, err = userEntity.Add(ctx, key, d)
 	if err != nil {
 		ctx.PrintError(w, err, http.StatusInternalServerError)
@@ -296,15 +325,34 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data = d.Output(ctx)
+	for name, value := range profileData.Output(ctx) {
+		data[name] = value
+	}
 
-	err = ctx.NewUserToken(d.Id, Role(d.Get(ctx, "role").(string)))
-	if err != nil {
-		ctx.PrintError(w, err, http.StatusInternalServerError)
+	role := Role(d.Get(ctx, "role").(string))
+	if verifyEmail {
+		err = sendVerification.Call(ctx.Context, d.Get(ctx, "email"), verificationURL, emailSender)
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if len(unverifiedRole) == 0 {
+			// user logs in after verification
+			ctx.Print(w, data)
+			return
+		}
+		role = unverifiedRole
+	}
+	if twoFactorRoles[role] {
+		// user logs in and sets up two-factor authentication
+		ctx.Print(w, data)
 		return
 	}
 
-	for name, value := range profileData.Output(ctx) {
-		data[name] = value
+	err = ctx.NewUserToken(d.Id, role)
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
 	}
 
 	ctx.Print(w, data)
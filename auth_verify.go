@@ -0,0 +1,167 @@
+package sdk
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/mail"
+)
+
+// VerificationTTL is how long email verification links work
+var VerificationTTL = 48 * time.Hour
+
+const verificationAudience = "verify"
+
+var (
+	ErrVerificationNotValid      = errors.New("verification token is not valid or has expired")
+	ErrVerificationNotConfigured = errors.New("verification url is not set")
+	ErrEmailNotVerified          = errors.New("email is not verified")
+)
+
+// set by EnableAuthAPI from AppOptions
+var (
+	verifyEmail     bool
+	unverifiedRole  Role
+	verificationURL string
+	emailSender     string
+)
+
+// verificationTemplate is executed with Link and Expires; AppOptions.VerificationTemplate replaces it
+var verificationTemplate = template.Must(template.New("email").Parse(
+	`<p>To confirm your email address open <a href="{{.Link}}">{{.Link}}</a>. The link expires in {{.Expires}}.</p>` +
+		`<p>If you didn't create an account, ignore this email.</p>`))
+
+// emailVerified returns true if user email is verified. Users registered before verification was enabled have
+// no emailVerified property and are verified; RegisterHandler stores false.
+func emailVerified(h *EntityDataHolder) bool {
+	value, ok := h.data[userEntity.fields["emailVerified"]]
+	if !ok {
+		return true
+	}
+	verified, _ := value.(bool)
+	return verified
+}
+
+// sendVerification emails signed verification link to user with unverified email. It runs in a task so that
+// requests of unknown emails take as long as the others.
+var sendVerification = delay.Func("sdk.sendVerification", func(c context.Context, email string, verifyURL string, sender string) error {
+	userKey := datastore.NewKey(c, userEntity.Name, email, 0, nil)
+	user, err := userEntity.stored(c, userKey)
+	if err == datastore.ErrNoSuchEntity || (err == nil && user.isDeleted()) {
+		logInfof(c, "verification of unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if emailVerified(user) {
+		return nil
+	}
+
+	token, err := signingKeys.sign(jwt.MapClaims{
+		"aud":   verificationAudience,
+		"exp":   time.Now().Add(VerificationTTL).Unix(),
+		"iat":   time.Now().Unix(),
+		"iss":   "sdk",
+		"sub":   userKey.Encode(),
+		"email": email,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := verifyURL + "?token=" + url.QueryEscape(token)
+	if strings.Contains(verifyURL, "?") {
+		link = verifyURL + "&token=" + url.QueryEscape(token)
+	}
+	ctx := Context{Context: c}
+	return ctx.SendEmail(&mail.Message{
+		Sender:  sender,
+		To:      []string{email},
+		Subject: "Confirm your email address",
+	}, verificationTemplate, map[string]interface{}{
+		"Link":    link,
+		"Expires": VerificationTTL.String(),
+	})
+})
+
+// VerifyEmail marks email of user in verification token as verified. Users get their role when they log in
+// again.
+func (c *Context) VerifyEmail(token string) error {
+	parsed, err := new(jwt.Parser).Parse(token, signingKeys.validationKey)
+	if err != nil || !parsed.Valid {
+		return ErrVerificationNotValid
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(verificationAudience, true) {
+		return ErrVerificationNotValid
+	}
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	userKey, err := datastore.DecodeKey(sub)
+	if err != nil || userKey.Kind() != userEntity.Name || userKey.StringID() != email {
+		return ErrVerificationNotValid
+	}
+
+	_, _, err = userEntity.edit(c.WithScopes(ScopeEdit), userKey, RevisionEdit, func() *EntityDataHolder {
+		return &EntityDataHolder{Entity: userEntity, data: Data{}}
+	}, func(h *EntityDataHolder) error {
+		h.data[userEntity.fields["emailVerified"]] = true
+		return nil
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return ErrVerificationNotValid
+	}
+	return err
+}
+
+// VerifyEmailHandler verifies email with token query parameter of verification link
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	err := ctx.VerifyEmail(r.URL.Query().Get("token"))
+	if err == ErrVerificationNotValid {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
+
+// ResendVerificationHandler emails new verification link to email in input. The response is the same whether the
+// account exists or not.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	if len(verificationURL) == 0 {
+		ctx.PrintError(w, ErrVerificationNotConfigured, http.StatusInternalServerError)
+		return
+	}
+
+	formHolder, _ := emptyEntity.FromForm(ctx)
+	email, _ := formHolder.GetInput("email").(string)
+	if !govalidator.IsEmail(email) {
+		ctx.PrintError(w, ErrEmailNotValid, http.StatusBadRequest)
+		return
+	}
+
+	if err := sendVerification.Call(ctx.Context, email, verificationURL, emailSender); err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Print(w, "ok")
+}
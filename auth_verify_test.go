@@ -0,0 +1,31 @@
+package sdk
+
+import "testing"
+
+func TestEmailVerifiedOfLegacyUser(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	legacy := addTestUser(t, ctx, "legacy@example.com", "user")
+	registered := addTestUser(t, ctx, "registered@example.com", "user")
+	user, err := userEntity.stored(ctx.Context, registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.data[userEntity.fields["emailVerified"]] = false
+	if _, err = store.Put(ctx.Context, registered, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// users stored before verification was enabled have no emailVerified property
+	if user, err = userEntity.stored(ctx.Context, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !emailVerified(user) {
+		t.Fatal("user without emailVerified property isn't verified")
+	}
+	if user, err = userEntity.stored(ctx.Context, registered); err != nil {
+		t.Fatal(err)
+	}
+	if emailVerified(user) {
+		t.Fatal("registered user with unverified email is verified")
+	}
+}
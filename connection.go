@@ -2,47 +2,72 @@ package sdk
 
 import (
 	"errors"
-	"fmt"
+	"strings"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 )
 
 var (
-	ErrNotAuthenticated = errors.New("not authenticated")
-	ErrNotAuthorized    = errors.New("not authorized")
-	ErrKeyIncomplete    = errors.New("key incomplete")
+	ErrNotAuthenticated   = errors.New("not authenticated")
+	ErrNotAuthorized      = errors.New("not authorized")
+	ErrKeyIncomplete      = errors.New("key incomplete")
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
 type EntityQueryFilter struct {
 	Name     string      `json:"name"`
-	Operator string      `json:"operator"` // =, <, <=, >, >=
+	Operator string      `json:"operator"` // =, !=, <, <=, >, >=, in (Value is []interface{})
 	Value    interface{} `json:"value"`
 }
 
-func (e *Entity) Query(ctx Context, sort string, limit int, offset int, filters ...EntityQueryFilter) ([]*EntityDataHolder, error) {
+// Query returns entities matching filters. If cursor is set, results continue where a previous query ended.
+// The returned cursor is empty when there are no more results.
+func (e *Entity) Query(ctx Context, sort string, limit int, offset int, cursor string, filters ...EntityQueryFilter) ([]*EntityDataHolder, string, error) {
+	return e.query(ctx, sort, limit, offset, cursor, false, filters...)
+}
+
+// query returns deleted entities instead of the rest if deleted is true
+func (e *Entity) query(ctx Context, sort string, limit int, offset int, cursor string, deleted bool, filters ...EntityQueryFilter) ([]*EntityDataHolder, string, error) {
 	var hs []*EntityDataHolder
+	var nextCursor string
 
 	if ctx.HasScope(e, ScopeRead) {
-		q := datastore.NewQuery(e.Name)
-
-		for _, filter := range filters {
-			if len(filter.Name) > 0 && len(filter.Operator) > 0 {
-				q = q.Filter(fmt.Sprintf("%s %s", filter.Name, filter.Operator), filter.Value)
-			}
+		var params = &QueryParams{Sort: sort, Limit: limit, Offset: offset, Cursor: cursor, Filters: filters, Deleted: deleted}
+		if err := e.queryHook(ctx, params); err != nil {
+			return hs, nextCursor, err
 		}
-
-		if len(sort) != 0 {
-			q = q.Order(sort)
+		limit = params.Limit
+
+		q := &StoreQuery{
+			Kind:    e.Name,
+			Filters: params.Filters,
+			Limit:   limit,
+			Offset:  params.Offset,
+			Cursor:  params.Cursor,
 		}
 
-		if limit != 0 {
-			q = q.Limit(limit)
+		// trash and private entities are filtered by the store so that pages are full
+		if e.SoftDelete && !deleted {
+			q.Filters = append(q.Filters, EntityQueryFilter{Name: deletedAtFieldName, Operator: "=", Value: nil})
+		}
+		if e.Private {
+			userKey, err := datastore.DecodeKey(ctx.User)
+			if err != nil {
+				return hs, nextCursor, nil
+			}
+			q.Filters = append(q.Filters, EntityQueryFilter{Name: "_createdBy", Operator: "=", Value: userKey})
 		}
 
-		q = q.Offset(offset)
+		// sort is a comma separated list of field names; "-" prefix sorts in descending order
+		for _, order := range strings.Split(params.Sort, ",") {
+			if order = strings.TrimSpace(order); len(order) != 0 {
+				q.Orders = append(q.Orders, order)
+			}
+		}
 
-		t := q.Run(ctx.Context)
+		var n int
+		t := store.Run(ctx.Context, q)
 		for {
 			var h = e.New(ctx)
 			h.isNew = false
@@ -51,34 +76,41 @@ func (e *Entity) Query(ctx Context, sort string, limit int, offset int, filters
 				break
 			}
 			if err != nil {
-				return hs, err
+				return hs, nextCursor, err
 			}
+			n++
 			h.Id = key.Encode()
 
-			if e.Private {
-				if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
-					continue
-				}
+			if err = e.hook(HookAfterRead, ctx, h); err != nil {
+				return hs, nextCursor, err
 			}
+			hs = append(hs, h)
+		}
 
-			if e.OnAfterRead != nil {
-				err = e.OnAfterRead(ctx, h)
+		// a full page means there might be more results
+		if limit != 0 && n == limit {
+			c, err := t.Cursor()
+			if err != nil {
+				return hs, nextCursor, err
 			}
-			hs = append(hs, h)
+			nextCursor = c
 		}
 
-		return hs, nil
+		return hs, nextCursor, nil
 	}
 
-	return hs, ErrNotAuthorized
+	return hs, nextCursor, ErrNotAuthorized
 }
 
 func (e *Entity) GetValues(ctx Context, key *datastore.Key, fieldNames ...string) (*EntityDataHolder, error) {
 	var h = e.New(ctx)
 	h.isNew = false
 	if ctx.HasScope(e, ScopeRead) {
-		q := datastore.NewQuery(e.Name).Filter("__key__", key).Project(fieldNames...)
-		t := q.Run(ctx.Context)
+		t := store.Run(ctx.Context, &StoreQuery{
+			Kind:    e.Name,
+			Filters: []EntityQueryFilter{{Name: "__key__", Operator: "=", Value: key}},
+			Project: fieldNames,
+		})
 		for {
 			key, err := t.Next(h)
 			if err == datastore.Done {
@@ -88,13 +120,16 @@ func (e *Entity) GetValues(ctx Context, key *datastore.Key, fieldNames ...string
 				return h, err
 			}
 			h.Id = key.Encode()
+			if h.isDeleted() {
+				continue
+			}
 			if e.Private {
 				if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
 					continue
 				}
 			}
-			if e.OnAfterRead != nil {
-				err = e.OnAfterRead(ctx, h)
+			if err = e.hook(HookAfterRead, ctx, h); err != nil {
+				return h, err
 			}
 		}
 		return h, nil
@@ -106,10 +141,13 @@ func (e *Entity) Get(ctx Context, key *datastore.Key) (*EntityDataHolder, error)
 	var h = e.New(ctx)
 	h.isNew = false
 	if ctx.HasScope(e, ScopeRead) {
-		err := datastore.Get(ctx.Context, key, h)
+		err := store.Get(ctx.Context, key, h)
 		if err != nil {
 			return h, err
 		}
+		if h.isDeleted() {
+			return h, datastore.ErrNoSuchEntity
+		}
 		encoded := key.Encode()
 		h.Id = encoded
 		if e.Private {
@@ -117,40 +155,121 @@ func (e *Entity) Get(ctx Context, key *datastore.Key) (*EntityDataHolder, error)
 				return nil, ErrNotAuthorized
 			}
 		}
-		if e.OnAfterRead != nil {
-			err = e.OnAfterRead(ctx, h)
-		}
+		err = e.hook(HookAfterRead, ctx, h)
 		return h, err
 	}
 	return h, ErrNotAuthorized
 }
 
+// Delete moves entity to trash if entity has SoftDelete enabled and removes it otherwise. OnDelete policies of
+// fields referencing the entity are applied together with the delete.
 func (e *Entity) Delete(ctx Context, key *datastore.Key) error {
+	if e.SoftDelete {
+		if !ctx.HasScope(e, ScopeDelete) {
+			return ErrNotAuthorized
+		}
+		if err := e.keyHook(HookBeforeDelete, ctx, key); err != nil {
+			return err
+		}
+		plan, err := e.planOnDelete(ctx, key)
+		if err != nil {
+			return err
+		}
+		err = plan.run(ctx, key, func(tc context.Context) error {
+			return e.trash(tc, ctx, key)
+		})
+		if err != nil {
+			return err
+		}
+		e.dispatchLater(ctx)
+		return e.keyHook(HookAfterDelete, ctx, key)
+	}
+	return e.Purge(ctx, key)
+}
+
+// Purge removes entity from datastore and search indexes, releases its unique values and applies OnDelete
+// policies. Trashed entities keep their unique values until purged.
+func (e *Entity) Purge(ctx Context, key *datastore.Key) error {
 	if ctx.HasScope(e, ScopeDelete) {
-		return datastore.Delete(ctx.Context, key)
+		if err := e.keyHook(HookBeforeDelete, ctx, key); err != nil {
+			return err
+		}
+		plan, err := e.planOnDelete(ctx, key)
+		if err != nil {
+			return err
+		}
+		err = plan.run(ctx, key, func(tc context.Context) error {
+			return e.purge(tc, ctx, key)
+		})
+		if err != nil {
+			return err
+		}
+		if e.hasRedirects() {
+			if err = e.releaseRedirects(ctx.Context, key); err != nil {
+				return err
+			}
+		}
+		e.dispatchLater(ctx)
+		return e.keyHook(HookAfterDelete, ctx, key)
 	}
 	return ErrNotAuthorized
 }
 
+// purge removes entity in transaction c. Removing entity that doesn't exist succeeds unless If-Match is set.
+func (e *Entity) purge(c context.Context, ctx Context, key *datastore.Key) error {
+	h, err := e.stored(c, key)
+	if err == datastore.ErrNoSuchEntity && len(ctx.ifMatch) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !h.matches(ctx.ifMatch) {
+		return ErrPreconditionFailed
+	}
+	if err := store.Delete(c, key); err != nil {
+		return err
+	}
+	if err := e.claimUnique(c, key, nil, h); err != nil {
+		return err
+	}
+	return e.recordChange(c, ctx, key, h.Version()+1, RevisionPurge, h.document(), nil)
+}
+
 func (e *Entity) Add(ctx Context, key *datastore.Key, h *EntityDataHolder) (*datastore.Key, error) {
 	var err error
 	var success bool
 	if ctx.HasScope(e, ScopeAdd) {
+		if err = e.checkReferences(ctx, h); err != nil {
+			return key, err
+		}
 		if !key.Incomplete() {
-			err = datastore.RunInTransaction(ctx.Context, func(tc context.Context) error {
+			err = store.RunInTransaction(ctx.Context, func(tc context.Context) error {
 				var tempEnt datastore.PropertyList
-				err := datastore.Get(tc, key, &tempEnt)
+				err := store.Get(tc, key, &tempEnt)
 				if err != nil {
 					if err == datastore.ErrNoSuchEntity {
-						if e.OnBeforeWrite != nil {
-							if err = e.OnBeforeWrite(ctx, h); err != nil {
-								return err
-							}
+						rev, err := e.firstRevision(tc, key)
+						if err != nil {
+							return err
+						}
+						h.setVersion(rev)
+						if err = e.beforeWrite(ctx, HookBeforeAdd, h); err != nil {
+							return err
 						}
-						key, err = datastore.Put(tc, key, h)
+						if err = e.setSlugs(tc, key, h, nil, nil); err != nil {
+							return err
+						}
+						key, err = store.Put(tc, key, h)
 						if err != nil {
 							return err
 						}
+						if err = e.recordChange(tc, ctx, key, rev, RevisionAdd, nil, h.document()); err != nil {
+							return err
+						}
+						if err = e.claimUnique(tc, key, h, nil); err != nil {
+							return err
+						}
 						encoded := key.Encode()
 						h.Id = encoded
 						success = true
@@ -161,15 +280,36 @@ func (e *Entity) Add(ctx Context, key *datastore.Key, h *EntityDataHolder) (*dat
 					return EntityAlreadyExists.Params(key.Kind())
 				}
 				return nil
-			}, nil)
+			})
 
 		} else {
-			if e.OnBeforeWrite != nil {
-				if err = e.OnBeforeWrite(ctx, h); err != nil {
-					return key, err
+			h.setVersion(1)
+			if err = e.beforeWrite(ctx, HookBeforeAdd, h); err != nil {
+				return key, err
+			}
+			var put = func(c context.Context) error {
+				if err := e.setSlugs(c, key, h, nil, nil); err != nil {
+					return err
+				}
+				k, err := store.Put(c, key, h)
+				if err != nil {
+					return err
+				}
+				if err = e.recordChange(c, ctx, k, 1, RevisionAdd, nil, h.document()); err != nil {
+					return err
+				}
+				if err = e.claimUnique(c, k, h, nil); err != nil {
+					return err
 				}
+				key = k
+				return nil
+			}
+			// unique values and change event are stored together with the new entity
+			if e.transactional() {
+				err = store.RunInTransaction(ctx.Context, put)
+			} else {
+				err = put(ctx.Context)
 			}
-			key, err = datastore.Put(ctx.Context, key, h)
 			if err != nil {
 				return key, err
 			}
@@ -179,10 +319,8 @@ func (e *Entity) Add(ctx Context, key *datastore.Key, h *EntityDataHolder) (*dat
 		}
 
 		if success {
-			e.PutToIndexes(ctx.Context, h.Id, h)
-			if e.OnAfterWrite != nil {
-				err = e.OnAfterWrite(ctx, h)
-			}
+			e.dispatchLater(ctx)
+			err = e.hook(HookAfterWrite, ctx, h)
 		}
 
 		return key, err
@@ -192,12 +330,59 @@ func (e *Entity) Add(ctx Context, key *datastore.Key, h *EntityDataHolder) (*dat
 
 func (e *Entity) Put(ctx Context, key *datastore.Key, h *EntityDataHolder) (*datastore.Key, error) {
 	if ctx.HasScope(e, ScopeWrite) {
-		if e.OnBeforeWrite != nil {
-			if err := e.OnBeforeWrite(ctx, h); err != nil {
-				return key, err
+		if err := e.checkReferences(ctx, h); err != nil {
+			return key, err
+		}
+
+		var put = func(c context.Context) error {
+			// version follows the stored entity
+			var old *EntityDataHolder
+			var before map[string]interface{}
+			h.setVersion(1)
+			if !key.Incomplete() {
+				var err error
+				old, err = e.stored(c, key)
+				switch err {
+				case nil:
+					before = old.document()
+					h.setVersion(old.Version() + 1)
+				case datastore.ErrNoSuchEntity:
+					rev, err := e.firstRevision(c, key)
+					if err != nil {
+						return err
+					}
+					h.setVersion(rev)
+				default:
+					return err
+				}
 			}
+
+			if err := e.beforeWrite(ctx, "", h); err != nil {
+				return err
+			}
+			if err := e.setSlugs(c, key, h, old, nil); err != nil {
+				return err
+			}
+			k, err := store.Put(c, key, h)
+			if err != nil {
+				return err
+			}
+			if err = e.recordChange(c, ctx, k, h.Version(), RevisionPut, before, h.document()); err != nil {
+				return err
+			}
+			if err = e.claimUnique(c, k, h, old); err != nil {
+				return err
+			}
+			key = k
+			return nil
+		}
+		// version, unique values and change event are stored together with the write
+		var err error
+		if e.transactional() || !key.Incomplete() {
+			err = store.RunInTransaction(ctx.Context, put)
+		} else {
+			err = put(ctx.Context)
 		}
-		key, err := datastore.Put(ctx.Context, key, h)
 		if err != nil {
 			return key, err
 		}
@@ -208,10 +393,8 @@ func (e *Entity) Put(ctx Context, key *datastore.Key, h *EntityDataHolder) (*dat
 				return nil, ErrNotAuthorized
 			}
 		}
-		e.PutToIndexes(ctx.Context, encoded, h)
-		if e.OnAfterWrite != nil {
-			err = e.OnAfterWrite(ctx, h)
-		}
+		e.dispatchLater(ctx)
+		err = e.hook(HookAfterWrite, ctx, h)
 		return key, err
 	}
 	return key, ErrNotAuthorized
@@ -309,50 +492,101 @@ func (e *Entity) Put(ctx Context, key *datastore.Key, h *EntityDataHolder) (*dat
 
 // currently it only check if entity exists and rewrites it
 func (e *Entity) Edit(ctx Context, key *datastore.Key, h *EntityDataHolder) (*datastore.Key, error) {
+	_, key, err := e.edit(ctx, key, RevisionEdit, func() *EntityDataHolder { return h }, nil)
+	return key, err
+}
+
+// edit loads stored entity into holder returned by newHolder, calls update and saves the holder in a transaction.
+// newHolder is called on every transaction attempt. Action is stored with revision.
+func (e *Entity) edit(ctx Context, key *datastore.Key, action string, newHolder func() *EntityDataHolder, update func(h *EntityDataHolder) error) (*EntityDataHolder, *datastore.Key, error) {
 	var err error
+	var h *EntityDataHolder
 	if ctx.HasScope(e, ScopeEdit) {
 		if !key.Incomplete() {
 			var success bool
-			err = datastore.RunInTransaction(ctx.Context, func(tc context.Context) error {
-
-				h.keepExistingValue = true // important!
-
-				err := datastore.Get(tc, key, h)
-				if err != nil {
+			err = store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+				var err error
+				if h, key, err = e.editIn(tc, ctx, key, action, newHolder, update); err != nil {
 					return err
 				}
-				if e.Private {
-					if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
-						return ErrNotAuthorized
-					}
-				}
-				h.keepExistingValue = false
-
-				if e.OnBeforeWrite != nil {
-					if err = e.OnBeforeWrite(ctx, h); err != nil {
-						return err
-					}
-				}
-				key, err = datastore.Put(tc, key, h)
-				if err != nil {
-					return err
-				}
-				encoded := key.Encode()
-				h.Id = encoded
 				success = true
-				return err
-			}, nil)
+				return nil
+			})
 
 			if success {
-				e.PutToIndexes(ctx.Context, h.Id, h)
-				if e.OnAfterWrite != nil {
-					err = e.OnAfterWrite(ctx, h)
-				}
+				e.dispatchLater(ctx)
+				err = e.hook(HookAfterWrite, ctx, h)
 			}
 		} else {
-			return key, ErrKeyIncomplete
+			return h, key, ErrKeyIncomplete
 		}
-		return key, err
+		return h, key, err
 	}
-	return key, ErrNotAuthorized
+	return h, key, ErrNotAuthorized
+}
+
+// editIn is the edit of transaction c. Callers dispatch changes and run after write hooks once c commits.
+func (e *Entity) editIn(c context.Context, ctx Context, key *datastore.Key, action string, newHolder func() *EntityDataHolder, update func(h *EntityDataHolder) error) (*EntityDataHolder, *datastore.Key, error) {
+	h := newHolder()
+	h.keepExistingValue = true // important!
+
+	// version is always loaded from the stored entity
+	delete(h.data, e.fields[versionFieldName])
+
+	err := store.Get(c, key, h)
+	if err != nil {
+		return h, key, err
+	}
+	if h.isDeleted() {
+		return h, key, datastore.ErrNoSuchEntity
+	}
+	if e.Private {
+		if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
+			return h, key, ErrNotAuthorized
+		}
+	}
+	h.keepExistingValue = false
+
+	var old *EntityDataHolder
+	var before map[string]interface{}
+	if e.History || e.transactional() {
+		if old, err = e.stored(c, key); err != nil {
+			return h, key, err
+		}
+		before = old.document()
+	}
+
+	if !h.matches(ctx.ifMatch) {
+		return h, key, ErrPreconditionFailed
+	}
+	h.setVersion(h.Version() + 1)
+
+	if update != nil {
+		if err = update(h); err != nil {
+			return h, key, err
+		}
+	}
+
+	if err = e.checkReferences(ctx, h); err != nil {
+		return h, key, err
+	}
+
+	if err = e.beforeWrite(ctx, HookBeforeEdit, h); err != nil {
+		return h, key, err
+	}
+	if err = e.setSlugs(c, key, h, old, nil); err != nil {
+		return h, key, err
+	}
+	key, err = store.Put(c, key, h)
+	if err != nil {
+		return h, key, err
+	}
+	if err = e.recordChange(c, ctx, key, h.Version(), action, before, h.document()); err != nil {
+		return h, key, err
+	}
+	if err = e.claimUnique(c, key, h, old); err != nil {
+		return h, key, err
+	}
+	h.Id = key.Encode()
+	return h, key, nil
 }
@@ -8,7 +8,6 @@ import (
 	"google.golang.org/appengine/datastore"
 	"io/ioutil"
 	"net/http"
-	"time"
 )
 
 type Context struct {
@@ -24,7 +23,8 @@ type Context struct {
 	IsAuthenticated bool
 	Token           Token
 
-	body *Body
+	body    *Body
+	ifMatch string
 }
 
 type Body struct {
@@ -33,7 +33,7 @@ type Body struct {
 }
 
 func NewContext(r *http.Request) Context {
-	isAuthenticated, userRole, userKey, renewedToken, err := getUser(r)
+	isAuthenticated, userRole, userKey, err := getUser(r)
 
 	if len(userRole) == 0 {
 		userRole = GuestRole
@@ -45,7 +45,6 @@ func NewContext(r *http.Request) Context {
 		IsAuthenticated: isAuthenticated,
 		Role:            userRole,
 		User:            userKey,
-		Token:           renewedToken,
 		err:             err,
 		body:            &Body{hasReadBody: false},
 	}
@@ -60,6 +59,13 @@ func (c Context) WithBody() Context {
 	return c
 }
 
+// WithIfMatch makes edits and deletes fail with ErrPreconditionFailed unless stored entity matches
+// one of the entity tags in If-Match header value
+func (c Context) WithIfMatch(ifMatch string) Context {
+	c.ifMatch = ifMatch
+	return c
+}
+
 // return true if userKey matches with userKey in token
 func (c Context) UserMatches(userKey interface{}) bool {
 	if userKeyString, ok := userKey.(string); ok {
@@ -72,11 +78,12 @@ func (c Context) UserMatches(userKey interface{}) bool {
 	return false
 }
 
-func getUser(r *http.Request) (bool, Role, string, Token, error) {
+// getUser returns user of valid access token. Expired tokens are not renewed; clients exchange refresh tokens
+// for new ones.
+func getUser(r *http.Request) (bool, Role, string, error) {
 	var isAuthenticated bool
 	var userRoleKey = ""
 	var userKey string
-	var renewedToken Token
 	var err error
 
 	tkn := gctx.Get(r, "user")
@@ -86,30 +93,24 @@ func getUser(r *http.Request) (bool, Role, string, Token, error) {
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			err = claims.Valid()
+			if err == nil && !claims.VerifyAudience("api", true) {
+				// tokens such as mfa tokens only work on their routes
+				return isAuthenticated, Role(userRoleKey), userKey, nil
+			}
 			if err == nil {
 				var username string
 				if username, ok = claims["sub"].(string); ok {
 					if userRoleKey, ok = claims["rol"].(string); ok {
-						return true, Role(userRoleKey), username, renewedToken, err
-					}
-				}
-				return isAuthenticated, Role(userRoleKey), userKey, renewedToken, ErrIllegalAction
-			} else if exp, ok := claims["exp"].(float64); ok {
-				// check if it's less than a week old
-				if time.Now().Unix()-int64(exp) < time.Now().Add(time.Hour*24*7).Unix() {
-					if userKey, ok := claims["sub"].(string); ok {
-						if userRoleKey, ok := claims["rol"].(string); ok {
-							renewedToken, err = newToken(userKey, Role(userRoleKey))
-							if err != nil {
-								return isAuthenticated, Role(userRoleKey), userKey, renewedToken, err
-							}
-							return true, Role(userRoleKey), userKey, renewedToken, err
-						}
+						return true, Role(userRoleKey), username, err
 					}
 				}
+				return isAuthenticated, Role(userRoleKey), userKey, ErrIllegalAction
+			} else if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+				// expired token is the same as no token
+				return isAuthenticated, Role(userRoleKey), userKey, nil
 			}
 		}
 	}
 
-	return isAuthenticated, Role(userRoleKey), userKey, renewedToken, err
+	return isAuthenticated, Role(userRoleKey), userKey, err
 }
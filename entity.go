@@ -13,14 +13,16 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/delay"
-	"google.golang.org/appengine/log"
-	"strconv"
+	"google.golang.org/appengine/search"
 )
 
 type Entity struct {
-	Name    string `json:"name"`    // Only a-Z characters allowed
-	Private bool   `json:"private"` // Protects entity with user field - only creator has access
-	Cache   Cache  `json:"-"`       // Keeps values in memcache - good for categories, translations, ...
+	Name       string `json:"name"`       // Only a-Z characters allowed
+	Private    bool   `json:"private"`    // Protects entity with user field - only creator has access
+	Cache      Cache  `json:"-"`          // Keeps values in memcache - good for categories, translations, ...
+	SoftDelete bool   `json:"softDelete"` // Delete moves entity to trash; it can be restored or purged. See MigrateSoftDelete
+	History    bool   `json:"history"`    // Keeps revision of every change; entity can be reverted to any of them
+	Webhooks   bool   `json:"webhooks"`   // Changes are sent to webhook subscriptions; see EnableWebhooks
 
 	fields map[string]*Field
 	Fields []*Field `json:"fields"`
@@ -44,13 +46,27 @@ type Entity struct {
 
 	indexes map[string]*DocumentDefinition
 
+	history *Entity // kind of revisions
+
+	uniqueFields  []*Field
+	slugFields    []*Field
+	uniqueMarkers *Entity // kind of markers reserving unique values
+
 	// Rules
 	Rules map[Role]map[Scope]bool `json:"rules"`
 
-	// Listener
-	OnBeforeWrite func(c Context, h *EntityDataHolder) error `json:"-"`
-	OnAfterRead   func(c Context, h *EntityDataHolder) error `json:"-"`
-	OnAfterWrite  func(c Context, h *EntityDataHolder) error `json:"-"`
+	// Listener; more hooks of each event can be added with AddHook
+	OnBeforeWrite  func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnAfterRead    func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnAfterWrite   func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnBeforeAdd    func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnBeforeEdit   func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnValidate     func(c Context, h *EntityDataHolder) error `json:"-"`
+	OnBeforeDelete func(c Context, key *datastore.Key) error  `json:"-"`
+	OnAfterDelete  func(c Context, key *datastore.Key) error  `json:"-"`
+	OnBeforeQuery  func(c Context, q *QueryParams) error      `json:"-"`
+
+	hooks map[string][]hook
 }
 
 type Cache struct {
@@ -82,8 +98,24 @@ func (e *Entity) init() (*Entity, error) {
 			panic(errors.New("field name can't start with an underscore"))
 		}
 
+		if !validOnDelete(field.OnDelete) {
+			return e, fmt.Errorf(ErrFieldOnDeleteNotValid, field.Name)
+		}
+		if field.OnDelete == OnDeleteSetNull && field.IsRequired {
+			return e, fmt.Errorf(ErrFieldSetNullRequired, field.Name)
+		}
+
 		e.AddField(field)
 
+		// slugs are looked up by uniqueness markers
+		if field.Type == SlugType {
+			field.Unique = true
+			e.slugFields = append(e.slugFields, field)
+		}
+		if field.Unique {
+			e.uniqueFields = append(e.uniqueFields, field)
+		}
+
 		// todo
 		if field.Type == FileType {
 			e.parse[field.Name] = Parser{
@@ -120,13 +152,24 @@ func (e *Entity) init() (*Entity, error) {
 			"label": "Updated",
 			"type":  "datetime",
 		},
+		Type:           DateTimeType,
 		isSpecialField: true,
 		ValueFunc: func() interface{} {
 			return time.Now()
 		},
 	})
+	e.AddField(&Field{
+		Name:           versionFieldName,
+		Type:           IntType,
+		NoEdits:        true,
+		isSpecialField: true,
+		Meta: Meta{
+			"label": "Version",
+		},
+	})
 	e.AddField(&Field{
 		Name:           "_createdBy",
+		Type:           KeyType,
 		isSpecialField: true,
 		Entity:         userEntity.Name,
 		ContextFunc: func(ctx Context) interface{} {
@@ -141,6 +184,7 @@ func (e *Entity) init() (*Entity, error) {
 	})
 	e.AddField(&Field{
 		Name:           "_updatedBy",
+		Type:           KeyType,
 		isSpecialField: true,
 		NoEdits:        true,
 		Entity:         userEntity.Name,
@@ -155,6 +199,44 @@ func (e *Entity) init() (*Entity, error) {
 		},
 	})
 
+	if e.SoftDelete {
+		e.AddField(&Field{
+			Name:           deletedAtFieldName,
+			Type:           DateTimeType,
+			NoEdits:        true,
+			isSpecialField: true,
+			Meta: Meta{
+				"label": "Deleted",
+				"type":  "datetime",
+			},
+		})
+		e.AddField(&Field{
+			Name:           deletedByFieldName,
+			Type:           KeyType,
+			NoEdits:        true,
+			isSpecialField: true,
+			Entity:         userEntity.Name,
+		})
+	}
+
+	if e.History {
+		if err := e.initHistory(); err != nil {
+			return e, err
+		}
+	}
+
+	for _, field := range e.slugFields {
+		if _, ok := e.fields[field.Source]; len(field.Source) != 0 && !ok {
+			return e, fmt.Errorf(ErrFieldSourceNotDefined, field.Name)
+		}
+	}
+
+	if len(e.uniqueFields) > 0 {
+		if err := e.initUnique(); err != nil {
+			return e, err
+		}
+	}
+
 	Entities[e.Name] = e
 
 	return e, nil
@@ -162,6 +244,7 @@ func (e *Entity) init() (*Entity, error) {
 
 var PublishedAt = &Field{
 	Name: "_publishedAt",
+	Type: DateTimeType,
 	Meta: Meta{
 		"label": "Published",
 		"type":  "datetime",
@@ -171,6 +254,7 @@ var PublishedAt = &Field{
 
 var CreatedAt = &Field{
 	Name:           "_createdAt",
+	Type:           DateTimeType,
 	NoEdits:        true,
 	isSpecialField: true,
 	Meta: Meta{
@@ -241,6 +325,11 @@ func (e *Entity) AddField(field *Field) {
 		e.requiredFields = append(e.requiredFields, field)
 	}
 
+	// long strings can't be indexed
+	if field.Type == TextType || field.Type == HTMLType {
+		field.NoIndex = true
+	}
+
 	if field.DefaultValue != nil {
 		e.preparedData[field] = func(ctx Context, f *Field) interface{} {
 			return f.DefaultValue
@@ -259,6 +348,9 @@ func (e *Entity) AddField(field *Field) {
 		}
 	}
 
+	// special fields are shared between entities
+	field.fieldFunc = nil
+
 	if len(field.ValidateRgx) > 0 {
 		field.fieldFunc = append(field.fieldFunc, func(c *ValueContext, v interface{}) (interface{}, error) {
 			if c.Trust == High {
@@ -304,6 +396,10 @@ func (e *Entity) AddField(field *Field) {
 	if field.TransformFunc != nil {
 		field.fieldFunc = append(field.fieldFunc, field.TransformFunc)
 	}
+
+	if fun := typeFunc(field); fun != nil {
+		field.fieldFunc = append(field.fieldFunc, fun)
+	}
 }
 
 /**
@@ -316,50 +412,66 @@ func (e *Entity) AddIndex(dd *DocumentDefinition) {
 	e.indexes[dd.Name] = dd
 }
 
-var putToIndex = delay.Func(RandStringBytesMaskImprSrc(16), func(ctx context.Context, dd DocumentDefinition, id string, data Data) {
+var putToIndex = delay.Func("sdk.putToIndex", func(ctx context.Context, dd DocumentDefinition, id string, data Data) {
 	err := dd.Put(ctx, id, flatOutput(id, data))
 	if err != nil {
-		log.Errorf(ctx, "%v", err.Error())
+		logErrorf(ctx, "%v", err.Error())
 	}
 })
-var removeFromIndex = delay.Func(RandStringBytesMaskImprSrc(16), func(ctx context.Context, dd DocumentDefinition) {
-	// do something expensive!
+var removeFromIndex = delay.Func("sdk.removeFromIndex", func(ctx context.Context, dd DocumentDefinition, id string) {
+	err := dd.Delete(ctx, id)
+	if err != nil && err != search.ErrNoSuchDocument {
+		logErrorf(ctx, "%v", err.Error())
+	}
 })
 
 func (e *Entity) PutToIndexes(ctx context.Context, id string, data *EntityDataHolder) {
 	for _, dd := range e.indexes {
-		if e.hasGeo {
-			latStr := data.data[e.latField]
-			lngStr := data.data[e.lngField]
-
-			if latStr != nil && lngStr != nil {
-				lat, err := strconv.ParseFloat(latStr.(string), 64)
-				if err != nil {
-					log.Errorf(ctx, "%v", err.Error())
-					return
-				}
-				lng, err := strconv.ParseFloat(lngStr.(string), 64)
-				if err != nil {
-					log.Errorf(ctx, "%v", err.Error())
-					return
-				}
-
-				geopoint := &appengine.GeoPoint{lat, lng}
-				data.data[&Field{
-					Name: "geopoint",
-				}] = geopoint
-			}
+		if err := e.addGeoPoint(data); err != nil {
+			logErrorf(ctx, "%v", err.Error())
+			return
 		}
 
 		err := putToIndex.Call(ctx, *dd, id, data.data)
 		if err != nil {
-			log.Errorf(ctx, "%v", err.Error())
+			logErrorf(ctx, "%v", err.Error())
+		}
+	}
+}
+
+// addGeoPoint adds geopoint value built from lat and lng fields for search
+func (e *Entity) addGeoPoint(data *EntityDataHolder) error {
+	if !e.hasGeo {
+		return nil
+	}
+
+	latStr := data.data[e.latField]
+	lngStr := data.data[e.lngField]
+
+	if latStr != nil && lngStr != nil {
+		// lat and lng are strings unless fields are typed
+		lat, ok := toFloat(latStr)
+		if !ok {
+			return fmt.Errorf(ErrFieldValueNotValid, e.latField.Name)
 		}
+		lng, ok := toFloat(lngStr)
+		if !ok {
+			return fmt.Errorf(ErrFieldValueNotValid, e.lngField.Name)
+		}
+
+		geopoint := &appengine.GeoPoint{lat, lng}
+		data.data[&Field{
+			Name: "geopoint",
+		}] = geopoint
 	}
+	return nil
 }
-func (e *Entity) RemoveFromIndexes(ctx context.Context) {
+func (e *Entity) RemoveFromIndexes(ctx context.Context, id string) {
 	for _, dd := range e.indexes {
-		removeFromIndex.Call(ctx, *dd)
+		err := removeFromIndex.Call(ctx, *dd, id)
+		if err != nil {
+			logErrorf(ctx, "%v", err.Error())
+		}
 	}
 }
 
@@ -424,8 +536,10 @@ func (e *Entity) NewKey(c Context, nameId interface{}) (Context, *datastore.Key,
 	return c, key, err
 }
 
+// FromForm reads form or JSON body input. Invalid fields are returned together as *ValidationError.
 func (e *Entity) FromForm(c Context) (*EntityDataHolder, error) {
 	var h = e.New(c)
+	var verr = &ValidationError{}
 
 	// e.parse only parses form values for now
 	/*for fieldName, fun := range e.parse {
@@ -446,9 +560,8 @@ func (e *Entity) FromForm(c Context) (*EntityDataHolder, error) {
 		for name, parser := range e.parse {
 			val, err := parser.ParseFunc(c, name)
 			if err == nil {
-				err = h.appendValue(name, val, Low)
-				if err != nil {
-					return h, err
+				if err = h.appendValue(name, val, Low); err != nil {
+					verr.add(name, err)
 				}
 			}
 		}
@@ -473,7 +586,7 @@ func (e *Entity) FromForm(c Context) (*EntityDataHolder, error) {
 						return h, err
 					}
 
-		*/ /*log.Infof(c.Context, "Appending file url '%s' value: %s", name, url)*/ /*
+		*/ /*logInfof(c.Context, "Appending file url '%s' value: %s", name, url)*/ /*
 
 					err = h.appendValue(name, "https://storage.googleapis.com/"+bucketName+"/"+url, Low)
 					if err != nil {
@@ -494,11 +607,10 @@ func (e *Entity) FromForm(c Context) (*EntityDataHolder, error) {
 			}
 
 			for _, v := range values {
-				/*log.Infof(c.Context, "Appending '%s' value: %v", name, v)*/
+				/*logInfof(c.Context, "Appending '%s' value: %v", name, v)*/
 
-				err = h.appendValue(name, v, Low)
-				if err != nil {
-					return h, err
+				if err := h.appendValue(name, v, Low); err != nil {
+					verr.add(name, err)
 				}
 			}
 		}
@@ -510,17 +622,21 @@ func (e *Entity) FromForm(c Context) (*EntityDataHolder, error) {
 			}
 
 			for _, v := range values {
-				err = h.appendValue(name, v, Low)
-				if err != nil {
-					return h, err
+				if err := h.appendValue(name, v, Low); err != nil {
+					verr.add(name, err)
 				}
 			}
 		}
 	} else {
 		return e.FromBody(c)
 	}
+	if err != nil {
+		return h, err
+	}
+
+	h.validateRequired(verr)
 
-	return h, err
+	return h, verr.errOrNil()
 }
 
 func (e *Entity) FromBody(c Context) (*EntityDataHolder, error) {
@@ -541,26 +657,32 @@ func (e *Entity) FromBody(c Context) (*EntityDataHolder, error) {
 	return e.FromMap(c, t)
 }
 
+// FromMap reads input map. Invalid fields are returned together as *ValidationError.
 func (e *Entity) FromMap(c Context, m map[string]interface{}) (*EntityDataHolder, error) {
 	var h = e.New(c)
-	var err error
+	var verr = &ValidationError{}
 
 	for name, value := range m {
+		// null is the same as no value
+		if value == nil {
+			continue
+		}
 		if _, ok := value.([]interface{}); ok || reflect.TypeOf(value).String() == "[]interface {}" {
 			for _, v := range value.([]interface{}) {
-				err = h.appendValue(name, v, Base)
-				if err != nil {
-					return h, err
+				if err := h.appendValue(name, v, Base); err != nil {
+					verr.add(name, err)
 				}
 			}
 		} else if _, ok := value.(interface{}); ok {
-			err = h.appendValue(name, value, Base)
-			if err != nil {
-				return h, err
+			if err := h.appendValue(name, value, Base); err != nil {
+				verr.add(name, err)
 			}
 		} else {
-			return h, fmt.Errorf(ErrFieldValueTypeNotValid, name)
+			verr.Add(&FieldError{Field: name, Code: CodeType, Message: fmt.Sprintf(ErrFieldValueTypeNotValid, name)})
 		}
 	}
-	return h, err
+
+	h.validateRequired(verr)
+
+	return h, verr.errOrNil()
 }
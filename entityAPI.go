@@ -12,13 +12,48 @@ import (
 var enabledEntityAPIs []*Entity
 
 func (a *SDK) enableEntityAPI(e *Entity) {
-	a.HandleFunc("/entity/"+e.Name, e.handleGetEntityInfo()).Methods(http.MethodGet)
-	a.HandleFunc("/"+e.Name+"/datatable", e.handleDataTable()).Methods(http.MethodGet)
-	a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleGet()).Methods(http.MethodGet)
-	a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleDelete()).Methods(http.MethodDelete)
-	a.HandleFunc("/"+e.Name, e.handleQuery()).Methods(http.MethodGet)
-	a.HandleFunc("/"+e.Name, e.handleAdd()).Methods(http.MethodPost)
-	a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleEdit()).Methods(http.MethodPost)
+	a.describe(a.HandleFunc("/entity/"+e.Name, e.handleGetEntityInfo()).Methods(http.MethodGet),
+		&apiOperation{summary: "Entity definition", tag: e.Name})
+	a.describe(a.HandleFunc("/entity/"+e.Name+"/schema", e.handleSchema()).Methods(http.MethodGet),
+		&apiOperation{summary: "Entity JSON Schema", tag: e.Name})
+	a.describe(a.HandleFunc("/"+e.Name+"/datatable", e.handleDataTable()).Methods(http.MethodGet),
+		&apiOperation{summary: "Datatable rows", tag: e.Name, query: []string{"sort", "limit", "offset", "cursor"}})
+	a.describe(a.HandleFunc("/"+e.Name+"/_export", e.handleExport()).Methods(http.MethodGet),
+		&apiOperation{summary: "Export " + e.Name + " as CSV or NDJSON", tag: e.Name, query: append([]string{"format"}, listQuery...)})
+	a.describe(a.HandleFunc("/"+e.Name+"/_import", e.handleImport()).Methods(http.MethodPost),
+		&apiOperation{summary: "Import " + e.Name + " from CSV or NDJSON", tag: e.Name, query: []string{"format", "dryRun", "upsert"}})
+	a.describe(a.HandleFunc("/"+e.Name+"/_batch", e.handleBatch()).Methods(http.MethodPost),
+		&apiOperation{summary: "Add, edit and delete " + e.Name + " in one request", tag: e.Name, query: []string{"transactional"}})
+	if len(e.slugFields) > 0 {
+		a.describe(a.HandleFunc("/"+e.Name+"/by-slug/{slug}", e.handleBySlug()).Methods(http.MethodGet),
+			&apiOperation{summary: "Get " + e.Name + " by slug; previous slugs redirect to the current one", tag: e.Name, entity: e, output: outputEntity, query: []string{"expand"}})
+	}
+	if e.SoftDelete {
+		a.describe(a.HandleFunc("/"+e.Name+"/_trash", e.handleTrash()).Methods(http.MethodGet),
+			&apiOperation{summary: "Deleted " + e.Name, tag: e.Name, entity: e, output: outputList, query: []string{"sort", "limit", "offset", "cursor"}})
+		a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}/restore", e.handleRestore()).Methods(http.MethodPost),
+			&apiOperation{summary: "Restore deleted " + e.Name, tag: e.Name, entity: e, output: outputEntity})
+	}
+	if e.History {
+		a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}/history", e.handleHistory()).Methods(http.MethodGet),
+			&apiOperation{summary: "Revisions of " + e.Name + ", last first", tag: e.Name, query: []string{"limit", "cursor"}})
+		a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}/revert/{rev:[0-9]+}", e.handleRevert()).Methods(http.MethodPost),
+			&apiOperation{summary: "Revert " + e.Name + " to revision", tag: e.Name, entity: e, output: outputEntity})
+	}
+	a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}/purge", e.handlePurge()).Methods(http.MethodDelete),
+		&apiOperation{summary: "Delete " + e.Name + " permanently", tag: e.Name})
+	a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleGet()).Methods(http.MethodGet),
+		&apiOperation{summary: "Get " + e.Name, tag: e.Name, entity: e, output: outputEntity, query: []string{"expand"}})
+	a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleDelete()).Methods(http.MethodDelete),
+		&apiOperation{summary: "Delete " + e.Name + "; moves it to trash if soft delete is enabled", tag: e.Name})
+	a.describe(a.HandleFunc("/"+e.Name, e.handleQuery()).Methods(http.MethodGet),
+		&apiOperation{summary: "Query " + e.Name, tag: e.Name, entity: e, output: outputList, query: listQuery})
+	a.describe(a.HandleFunc("/"+e.Name, e.handleAdd()).Methods(http.MethodPost),
+		&apiOperation{summary: "Add " + e.Name, tag: e.Name, entity: e, input: true, output: outputEntity})
+	a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handleEdit()).Methods(http.MethodPost),
+		&apiOperation{summary: "Edit " + e.Name, tag: e.Name, entity: e, input: true, output: outputEntity})
+	a.describe(a.HandleFunc("/"+e.Name+"/{encodedKey}", e.handlePatch()).Methods(http.MethodPatch),
+		&apiOperation{summary: "Patch " + e.Name + " with JSON Merge Patch or JSON Patch", tag: e.Name, entity: e, output: outputEntity})
 
 	//a.HandleFunc("/"+e.Name+"/{encodedKey}/_url", e.handleSetURL()).Methods(http.MethodPost)
 
@@ -39,21 +74,6 @@ func (e *Entity) handleGet() func(w http.ResponseWriter, r *http.Request) {
 
 		encodedKey := vars["encodedKey"]
 
-		slug := r.FormValue("slug")
-		if len(slug) > 0 {
-			filter := EntityQueryFilter{
-				Name:     "slug",
-				Operator: "=",
-				Value:    encodedKey,
-			}
-
-			dataHolder, err := e.Query(ctx, "", 1, 0, filter)
-			if err == nil && len(dataHolder) > 0 {
-				ctx.Print(w, dataHolder[0].Output(ctx))
-				return
-			}
-		}
-
 		ctx, key, err := e.DecodeKey(ctx, encodedKey)
 		if err != nil {
 			ctx.PrintError(w, err, http.StatusBadRequest)
@@ -61,18 +81,29 @@ func (e *Entity) handleGet() func(w http.ResponseWriter, r *http.Request) {
 		}
 
 		dataHolder, err := e.Get(ctx, key)
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			ctx.PrintError(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		ctx.Print(w, dataHolder.Output(ctx))
+		data, err := e.outputs(ctx, []*EntityDataHolder{dataHolder}, r.FormValue("expand"))
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("ETag", dataHolder.ETag())
+		ctx.Print(w, data[0])
 	}
 }
 
 func (e *Entity) handleDelete() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContext(r)
+		ctx := NewContext(r).WithIfMatch(r.Header.Get("If-Match"))
 		vars := mux.Vars(r)
 
 		encodedKey := vars["encodedKey"]
@@ -85,7 +116,7 @@ func (e *Entity) handleDelete() func(w http.ResponseWriter, r *http.Request) {
 
 		err = e.Delete(ctx, key)
 		if err != nil {
-			ctx.PrintError(w, err, http.StatusInternalServerError)
+			ctx.PrintError(w, err, errorStatus(err))
 			return
 		}
 
@@ -108,7 +139,7 @@ func (e *Entity) handleAdd() func(w http.ResponseWriter, r *http.Request) {
 
 		key, err = e.Add(ctx, key, holder)
 		if err != nil {
-			ctx.PrintError(w, err, http.StatusInternalServerError)
+			ctx.PrintError(w, err, errorStatus(err))
 			return
 		}
 
@@ -118,13 +149,13 @@ func (e *Entity) handleAdd() func(w http.ResponseWriter, r *http.Request) {
 
 func (e *Entity) handleEdit() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContext(r)
+		ctx := NewContext(r).WithIfMatch(r.Header.Get("If-Match"))
 		vars := mux.Vars(r)
 		encodedKey := vars["encodedKey"]
 
 		holder, err := e.FromForm(ctx)
-		if err != nil {
-			ctx.PrintError(w, err, http.StatusInternalServerError)
+		if err = partialInput(err); err != nil {
+			ctx.PrintError(w, err, errorStatus(err))
 			return
 		}
 
@@ -141,10 +172,11 @@ func (e *Entity) handleEdit() func(w http.ResponseWriter, r *http.Request) {
 
 		key, err = e.Edit(ctx, key, holder)
 		if err != nil {
-			ctx.PrintError(w, err, http.StatusInternalServerError)
+			ctx.PrintError(w, err, errorStatus(err))
 			return
 		}
 
+		w.Header().Set("ETag", holder.ETag())
 		ctx.Print(w, holder.Output(ctx))
 	}
 }
@@ -187,6 +219,7 @@ func (e *Entity) handleDataTable() func(w http.ResponseWriter, r *http.Request)
 		search := q.Get("search[value]")*/
 
 		sort := q.Get("sort")
+		cursor := q.Get("cursor")
 		limitStr := q.Get("limit")
 		offsetStr := q.Get("offset")
 		var limit = 0
@@ -198,7 +231,11 @@ func (e *Entity) handleDataTable() func(w http.ResponseWriter, r *http.Request)
 			offset, _ = strconv.Atoi(offsetStr)
 		}
 
-		dataHolder, err := e.Query(ctx, sort, offset, limit)
+		dataHolder, nextCursor, err := e.Query(ctx, sort, limit, offset, cursor)
+		if err == ErrInvalidCursor {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			ctx.PrintError(w, err, http.StatusInternalServerError)
 			return
@@ -215,7 +252,8 @@ func (e *Entity) handleDataTable() func(w http.ResponseWriter, r *http.Request)
 		}
 
 		printOut(w, Result{
-			"data": data,
+			"data":       data,
+			"nextCursor": nextCursor,
 		})
 	}
 }
@@ -227,6 +265,7 @@ func (e *Entity) handleQuery() func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query()
 
 		sort := q.Get("sort")
+		cursor := q.Get("cursor")
 		limitStr := q.Get("limit")
 		offsetStr := q.Get("offset")
 		var limit = 0
@@ -238,53 +277,56 @@ func (e *Entity) handleQuery() func(w http.ResponseWriter, r *http.Request) {
 			offset, _ = strconv.Atoi(offsetStr)
 		}
 
-		filterField := q.Get("filter[field]")
-		filterOp := q.Get("filter[op]")
-		filterValue := q.Get("filter[value]")
-
-		var filter EntityQueryFilter
-		if len(filterField) > 0 && len(filterOp) > 0 {
-
-			var op string
-			switch filterOp {
-			case "eq":
-				op = "="
-				break
-			case "lt":
-				op = "<"
-				break
-			case "le":
-				op = "<="
-				break
-			case "gt":
-				op = ">"
-				break
-			case "ge":
-				op = ">="
-				break
-			}
+		filters, err := e.ParseQueryFilters(q)
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
 
-			filter = EntityQueryFilter{
-				Name:     filterField,
-				Operator: op,
-				Value:    filterValue,
-			}
+		sort, err = e.ParseQuerySort(sort)
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
 		}
 
-		dataHolder, err := e.Query(ctx, sort, limit, offset, filter)
+		dataHolder, nextCursor, err := e.Query(ctx, sort, limit, offset, cursor, filters...)
+		if err == ErrInvalidCursor {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			ctx.PrintError(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		var data []map[string]interface{}
-		for _, h := range dataHolder {
-			data = append(data, h.Output(ctx))
+		data, err := e.outputs(ctx, dataHolder, q.Get("expand"))
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
 		}
 
 		ctx.Print(w, map[string]interface{}{
-			"data":  data,
-			"count": len(data),
+			"data":       data,
+			"count":      len(data),
+			"nextCursor": nextCursor,
 		})
 	}
 }
+
+// errorStatus returns bad request status for invalid input, precondition failed status for version mismatch,
+// conflict status for deletes restricted by references and used unique values and internal error status otherwise
+func errorStatus(err error) int {
+	switch err {
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case ErrTooManyReferences:
+		return http.StatusConflict
+	}
+	switch err.(type) {
+	case *ValidationError, *FieldError:
+		return http.StatusBadRequest
+	case *ReferenceError, *UniqueError:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,550 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// batch operations
+const (
+	BatchAdd    = "add"
+	BatchEdit   = "edit"
+	BatchDelete = "delete"
+)
+
+const (
+	maxBatchOperations   = 500 // most entities datastore writes in one call
+	maxTransactionGroups = 25  // most entity groups datastore writes in one transaction
+)
+
+const ErrBatchOperationNotSupported string = "batch operation '%s' is not supported"
+
+var (
+	ErrBatchTooLarge = fmt.Errorf("batch can have at most %d operations or %d if transactional",
+		maxBatchOperations, maxTransactionGroups)
+	ErrBatchNotApplied = errors.New("batch not applied")
+)
+
+type BatchOperation struct {
+	Op      string                 `json:"op"`      // add, edit or delete
+	Id      string                 `json:"id"`      // encoded key of edited or deleted entity
+	IfMatch string                 `json:"ifMatch"` // same as If-Match header of single edit or delete
+	Data    map[string]interface{} `json:"data"`    // input of add or edit
+}
+
+type BatchResult struct {
+	Id      string                 `json:"id,omitempty"`
+	Status  int                    `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Errors  map[string]*FieldError `json:"errors,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+type batchItem struct {
+	op     *BatchOperation
+	key    *datastore.Key
+	h      *EntityDataHolder
+	stored *EntityDataHolder      // stored edited or deleted entity
+	before map[string]interface{} // stored document of edited or deleted entity
+	remove bool                   // entity is removed from store instead of put
+	plan   *onDeletePlan          // changes of OnDelete policies of deleted entity
+	err    error
+}
+
+// Batch applies operations. Input is checked as with FromMap. If transactional is true, operations are written
+// with PutMulti and DeleteMulti in one transaction: either all are applied or none and ErrBatchNotApplied is
+// returned. Datastore transactions can write at most 25 entity groups; unique markers, change events and
+// changes of OnDelete policies count too. Otherwise operations are written in chunks that fit in one transaction
+// and each operation succeeds or fails on its own. Search indexes are updated from change events of the batch.
+func (e *Entity) Batch(ctx Context, ops []BatchOperation, transactional bool) ([]*BatchResult, error) {
+	if len(ops) > maxBatchOperations || (transactional && len(ops) > maxTransactionGroups) {
+		return nil, ErrBatchTooLarge
+	}
+	if !transactional {
+		results := e.batchEach(ctx, ops)
+		e.dispatchLater(ctx)
+		return results, nil
+	}
+
+	items, err := e.batchInTransaction(ctx, ops)
+	if err == ErrBatchTooLarge {
+		return nil, err
+	}
+	if err == nil && len(items) > 0 {
+		e.dispatchLater(ctx)
+	}
+	return e.batchResults(ctx, items, err), err
+}
+
+// batchInTransaction applies operations in one transaction. Unless it returns nil, nothing is applied and
+// items that didn't fail on their own have no error.
+func (e *Entity) batchInTransaction(ctx Context, ops []BatchOperation) ([]*batchItem, error) {
+	var items []*batchItem
+	err := store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+		// items are prepared again on retry as stored values might have changed
+		var err error
+		items, err = e.applyBatch(tc, ctx, ops)
+		return err
+	})
+	if err != nil && err != ErrBatchNotApplied && err != ErrBatchTooLarge {
+		for _, it := range items {
+			if it.err == nil {
+				it.err = err
+			}
+		}
+		err = ErrBatchNotApplied
+	}
+	return items, err
+}
+
+// batchResults returns results of items and runs after hooks of applied ones
+func (e *Entity) batchResults(ctx Context, items []*batchItem, err error) []*BatchResult {
+	var results []*BatchResult
+	for _, it := range items {
+		if it.err == nil && err == ErrBatchNotApplied {
+			results = append(results, &BatchResult{Id: it.op.Id, Status: http.StatusFailedDependency, Message: err.Error()})
+			continue
+		}
+		if it.err != nil {
+			results = append(results, batchResult(ctx, it))
+			continue
+		}
+
+		var message string
+		switch it.op.Op {
+		case BatchAdd, BatchEdit:
+			it.h.Id = it.key.Encode()
+			if err := e.hook(HookAfterWrite, ctx, it.h); err != nil {
+				message = err.Error()
+			}
+		case BatchDelete:
+			if it.remove && e.hasRedirects() {
+				if err := e.releaseRedirects(ctx.Context, it.key); err != nil {
+					message = err.Error()
+				}
+			}
+			if err := it.plan.after(ctx); err != nil {
+				message = err.Error()
+			} else if err := e.keyHook(HookAfterDelete, ctx, it.key); err != nil {
+				message = err.Error()
+			}
+		}
+		r := batchResult(ctx, it)
+		r.Message = message
+		results = append(results, r)
+	}
+	return results
+}
+
+// batchEach applies operations in chunks of as many as fit in one transaction; each succeeds or fails on its own
+func (e *Entity) batchEach(ctx Context, ops []BatchOperation) []*BatchResult {
+	var results []*BatchResult
+	var size = maxTransactionGroups / e.writeGroups()
+	if size < 1 {
+		size = 1
+	}
+	for start := 0; start < len(ops); start += size {
+		end := start + size
+		if end > len(ops) {
+			end = len(ops)
+		}
+		results = append(results, e.batchChunk(ctx, ops[start:end])...)
+	}
+	return results
+}
+
+// batchChunk applies operations in one transaction. Failed operations are left out and the rest is applied
+// again; operations that don't fit in one transaction, e.g. deletes with OnDelete changes, are split in halves.
+func (e *Entity) batchChunk(ctx Context, ops []BatchOperation) []*BatchResult {
+	var results = make([]*BatchResult, len(ops))
+	var pending []int // indexes of operations that are neither applied nor failed
+	for i := range ops {
+		pending = append(pending, i)
+	}
+
+	for len(pending) > 0 {
+		var chunk []BatchOperation
+		for _, i := range pending {
+			chunk = append(chunk, ops[i])
+		}
+
+		items, err := e.batchInTransaction(ctx, chunk)
+		if err == ErrBatchTooLarge {
+			var rs []*BatchResult
+			if len(chunk) == 1 {
+				rs = []*BatchResult{{Id: chunk[0].Id, Status: http.StatusRequestEntityTooLarge, Message: err.Error()}}
+			} else {
+				half := len(chunk) / 2
+				rs = append(e.batchChunk(ctx, chunk[:half]), e.batchChunk(ctx, chunk[half:])...)
+			}
+			for j, i := range pending {
+				results[i] = rs[j]
+			}
+			break
+		}
+
+		rs := e.batchResults(ctx, items, err)
+		var retry []int
+		for j, it := range items {
+			if it.err == nil && err == ErrBatchNotApplied {
+				retry = append(retry, pending[j])
+				continue
+			}
+			results[pending[j]] = rs[j]
+		}
+		pending = retry
+	}
+	return results
+}
+
+// batchResult returns result of applied or failed item
+func batchResult(ctx Context, it *batchItem) *BatchResult {
+	var r = &BatchResult{Id: it.op.Id}
+	if it.err != nil {
+		r.Status = batchStatus(it.err)
+		r.Message = it.err.Error()
+		r.Errors = fieldErrors(it.err)
+		return r
+	}
+
+	r.Id = it.key.Encode()
+	r.Status = http.StatusOK
+	switch it.op.Op {
+	case BatchAdd:
+		r.Status = http.StatusCreated
+		fallthrough
+	case BatchEdit:
+		it.h.Id = r.Id
+		r.Data = it.h.Output(ctx)
+	}
+	return r
+}
+
+// applyBatch writes operations in transaction c; nothing is written when any operation fails
+func (e *Entity) applyBatch(c context.Context, ctx Context, ops []BatchOperation) ([]*batchItem, error) {
+	var items []*batchItem
+	for i := range ops {
+		items = append(items, e.prepareBatchItem(ctx, &ops[i]))
+	}
+	if batchFailed(items) {
+		return items, ErrBatchNotApplied
+	}
+
+	// load stored entities of edits and deletes
+	var loaded []*batchItem
+	var keys []*datastore.Key
+	var dst []datastore.PropertyLoadSaver
+	for _, it := range items {
+		if it.err == nil && it.op.Op != BatchAdd {
+			loaded = append(loaded, it)
+			keys = append(keys, it.key)
+			dst = append(dst, &EntityDataHolder{Entity: e, data: Data{}})
+		}
+	}
+	if len(keys) > 0 {
+		if err := setBatchErrors(loaded, store.GetMulti(c, keys, dst)); err != nil {
+			return items, err
+		}
+	}
+	for i, it := range loaded {
+		if it.err == nil {
+			it.err = e.applyStored(ctx, it, dst[i].(*EntityDataHolder))
+		}
+	}
+
+	var puts, removes []*batchItem
+	var reserved = map[string]bool{}
+	for _, it := range items {
+		if it.err != nil {
+			continue
+		}
+		if it.remove {
+			removes = append(removes, it)
+			continue
+		}
+		if it.err = e.checkReferences(ctx, it.h); it.err != nil {
+			continue
+		}
+		if it.err = e.setSlugs(c, it.key, it.h, it.stored, reserved); it.err != nil {
+			continue
+		}
+		if it.err = e.reserveUnique(c, it.key, it.h, reserved); it.err != nil {
+			continue
+		}
+		// soft deletes ran delete hooks
+		if it.op.Op != BatchDelete {
+			var event = HookBeforeEdit
+			if it.op.Op == BatchAdd {
+				event = HookBeforeAdd
+			}
+			if it.err = e.beforeWrite(ctx, event, it.h); it.err != nil {
+				continue
+			}
+		}
+		puts = append(puts, it)
+	}
+	if batchFailed(items) {
+		return items, ErrBatchNotApplied
+	}
+
+	var groups int
+	for _, it := range items {
+		if it.plan != nil {
+			groups += it.plan.groups
+		} else {
+			groups += e.writeGroups()
+		}
+	}
+	if groups > maxTransactionGroups {
+		return items, ErrBatchTooLarge
+	}
+
+	if len(puts) > 0 {
+		var keys []*datastore.Key
+		var src []datastore.PropertyLoadSaver
+		for _, it := range puts {
+			keys = append(keys, it.key)
+			src = append(src, it.h)
+		}
+		putKeys, err := store.PutMulti(c, keys, src)
+		if err = setBatchErrors(puts, err); err != nil {
+			return items, err
+		}
+		for i, it := range puts {
+			if it.err == nil {
+				it.key = putKeys[i]
+			}
+		}
+	}
+
+	if len(removes) > 0 {
+		var keys []*datastore.Key
+		for _, it := range removes {
+			keys = append(keys, it.key)
+		}
+		if err := setBatchErrors(removes, store.DeleteMulti(c, keys)); err != nil {
+			return items, err
+		}
+	}
+	if batchFailed(items) {
+		return items, ErrBatchNotApplied
+	}
+
+	for _, it := range items {
+		if it.err != nil || len(e.uniqueFields) == 0 {
+			continue
+		}
+		if it.remove {
+			it.err = e.claimUnique(c, it.key, nil, it.stored)
+		} else {
+			it.err = e.claimUnique(c, it.key, it.h, it.stored)
+		}
+		if it.err != nil {
+			return items, ErrBatchNotApplied
+		}
+	}
+
+	for _, it := range items {
+		if it.err != nil || (e.history == nil && !e.hasSubscribers()) {
+			continue
+		}
+		switch {
+		case it.op.Op == BatchAdd:
+			it.err = e.recordChange(c, ctx, it.key, 1, RevisionAdd, nil, it.h.document())
+		case it.remove:
+			it.err = e.recordChange(c, ctx, it.key, it.h.Version()+1, RevisionPurge, it.before, nil)
+		case it.op.Op == BatchDelete:
+			it.err = e.recordChange(c, ctx, it.key, it.h.Version(), RevisionDelete, it.before, it.h.document())
+		default:
+			it.err = e.recordChange(c, ctx, it.key, it.h.Version(), RevisionEdit, it.before, it.h.document())
+		}
+		if it.err != nil {
+			return items, ErrBatchNotApplied
+		}
+	}
+
+	// OnDelete policies leave out entities the batch writes
+	var written = map[string]bool{}
+	for _, it := range items {
+		written[it.key.Encode()] = true
+	}
+	for _, it := range items {
+		if it.plan == nil {
+			continue
+		}
+		if it.err = it.plan.apply(c, ctx, written); it.err != nil {
+			return items, ErrBatchNotApplied
+		}
+	}
+
+	return items, nil
+}
+
+// prepareBatchItem checks scope and key of operation and reads its input
+func (e *Entity) prepareBatchItem(ctx Context, op *BatchOperation) *batchItem {
+	var it = &batchItem{op: op}
+
+	var scope Scope
+	switch op.Op {
+	case BatchAdd:
+		scope = ScopeAdd
+	case BatchEdit:
+		scope = ScopeEdit
+	case BatchDelete:
+		scope = ScopeDelete
+	default:
+		it.err = &FieldError{Field: "op", Code: CodeInvalid, Message: fmt.Sprintf(ErrBatchOperationNotSupported, op.Op)}
+		return it
+	}
+	if !ctx.HasScope(e, scope) {
+		it.err = ErrNotAuthorized
+		return it
+	}
+
+	if op.Op == BatchAdd {
+		_, it.key = e.NewIncompleteKey(ctx)
+	} else {
+		var err error
+		if _, it.key, err = e.DecodeKey(ctx, op.Id); err != nil {
+			it.err = &FieldError{Field: "id", Code: CodeInvalid, Message: err.Error()}
+			return it
+		}
+		if it.key.Kind() != e.Name || it.key.Incomplete() {
+			it.err = datastore.ErrNoSuchEntity
+			return it
+		}
+	}
+
+	if op.Op == BatchDelete {
+		return it
+	}
+
+	h, err := e.FromMap(ctx, op.Data)
+	if op.Op == BatchEdit {
+		h.isNew = false
+		err = partialInput(err)
+	} else {
+		h.setVersion(1)
+	}
+	it.h = h
+	it.err = err
+	return it
+}
+
+// applyStored applies edit or delete operation to stored entity
+func (e *Entity) applyStored(ctx Context, it *batchItem, stored *EntityDataHolder) error {
+	if stored.isDeleted() {
+		return datastore.ErrNoSuchEntity
+	}
+	if e.Private {
+		if !ctx.UserMatches(stored.Get(ctx, "_createdBy")) {
+			return ErrNotAuthorized
+		}
+	}
+	if !stored.matches(it.op.IfMatch) {
+		return ErrPreconditionFailed
+	}
+	it.stored = stored
+	it.before = stored.document()
+
+	if it.op.Op == BatchDelete {
+		if err := e.keyHook(HookBeforeDelete, ctx, it.key); err != nil {
+			return err
+		}
+		var err error
+		if it.plan, err = e.planOnDelete(ctx, it.key); err != nil {
+			return err
+		}
+		it.h = stored
+		if !e.SoftDelete {
+			it.remove = true
+			return nil
+		}
+		stored.data[e.fields[deletedAtFieldName]] = time.Now()
+		if userKey, err := datastore.DecodeKey(ctx.User); err == nil {
+			stored.data[e.fields[deletedByFieldName]] = userKey
+		}
+		stored.setVersion(stored.Version() + 1)
+		return nil
+	}
+
+	// input values replace stored ones
+	for field, value := range stored.data {
+		if _, ok := it.h.data[field]; !ok {
+			it.h.data[field] = value
+		}
+	}
+	it.h.setVersion(stored.Version() + 1)
+	return it.h.Validate()
+}
+
+// setBatchErrors sets errors of multi call to items; other errors are returned
+func setBatchErrors(items []*batchItem, err error) error {
+	if err == nil {
+		return nil
+	}
+	multiErr, ok := err.(appengine.MultiError)
+	if !ok {
+		return err
+	}
+	for i, it := range items {
+		if multiErr[i] != nil {
+			it.err = multiErr[i]
+		}
+	}
+	return nil
+}
+
+func batchFailed(items []*batchItem) bool {
+	for _, it := range items {
+		if it.err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func batchStatus(err error) int {
+	switch err {
+	case datastore.ErrNoSuchEntity:
+		return http.StatusNotFound
+	case ErrNotAuthorized:
+		return http.StatusForbidden
+	}
+	return errorStatus(err)
+}
+
+func (e *Entity) handleBatch() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r).WithBody()
+
+		var ops []BatchOperation
+		if err := json.Unmarshal(ctx.body.body, &ops); err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		results, err := e.Batch(ctx, ops, r.URL.Query().Get("transactional") == "true")
+		if err == ErrBatchTooLarge {
+			ctx.PrintError(w, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err == ErrBatchNotApplied {
+			write(w, ctx.Token, http.StatusConflict, err, responseKey, results)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Print(w, results)
+	}
+}
@@ -0,0 +1,64 @@
+package sdk
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestBatchEach(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTestEntity(t, "batchItem")
+	edited := addTestItem(t, ctx, e, map[string]interface{}{"name": "edited"})
+	deleted := addTestItem(t, ctx, e, map[string]interface{}{"name": "deleted"})
+
+	// more operations than fit in one transaction; failed ones don't stop the others
+	var ops []BatchOperation
+	for i := 0; i < maxTransactionGroups+5; i++ {
+		ops = append(ops, BatchOperation{Op: BatchAdd, Data: map[string]interface{}{"name": "a", "n": strconv.Itoa(i)}})
+	}
+	ops[3].Data = map[string]interface{}{"n": "3"}
+	ops = append(ops,
+		BatchOperation{Op: BatchEdit, Id: edited.Encode(), IfMatch: `"99"`, Data: map[string]interface{}{"name": "b"}},
+		BatchOperation{Op: BatchDelete, Id: deleted.Encode()},
+	)
+
+	results, err := e.Batch(ctx, ops, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(ops) {
+		t.Fatalf("batch returned %d results, want %d", len(results), len(ops))
+	}
+	for i, r := range results[:maxTransactionGroups+5] {
+		want := http.StatusCreated
+		if i == 3 {
+			want = http.StatusBadRequest
+		}
+		if r.Status != want {
+			t.Fatalf("status of add %d is %d, want %d", i, r.Status, want)
+		}
+	}
+	if r := results[len(ops)-2]; r.Status != http.StatusPreconditionFailed {
+		t.Fatalf("status of edit with stale If-Match is %d, want 412", r.Status)
+	}
+	if r := results[len(ops)-1]; r.Status != http.StatusOK {
+		t.Fatalf("status of delete is %d, want 200", r.Status)
+	}
+
+	hs, _, err := e.Query(ctx, "", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != maxTransactionGroups+5 {
+		t.Fatalf("query returned %d entities, want %d", len(hs), maxTransactionGroups+5)
+	}
+	if _, err = e.Get(ctx, deleted); err != datastore.ErrNoSuchEntity {
+		t.Fatalf("get of deleted entity returned %v, want ErrNoSuchEntity", err)
+	}
+	if h, _ := e.Get(ctx, edited); h.Get(ctx, "name") != "edited" {
+		t.Fatal("edit with stale If-Match is applied")
+	}
+}
@@ -52,11 +52,11 @@ func (e *EntityDataHolder) Get(ctx Context, name string) interface{} {
 				endValue = []interface{}{}
 
 				for _, v := range value.([]interface{}) {
-					endV, _ := Entities[field.Entity].Lookup(ctx, v.(string))
+					endV, _ := Entities[field.Entity].Lookup(ctx, encodedKey(v))
 					endValue = append(endValue.([]interface{}), endV)
 				}
 			} else {
-				endValue, _ = Entities[field.Entity].Lookup(ctx, value.(string))
+				endValue, _ = Entities[field.Entity].Lookup(ctx, encodedKey(value))
 			}
 		} else {
 			endValue = value
@@ -126,7 +126,9 @@ func output(ctx Context, id string, data Data, cacheLookup bool) map[string]inte
 					}
 
 					if doCacheLookup {
-						v, _ = Entities[field.Entity].Lookup(ctx, v.(string))
+						v, _ = Entities[field.Entity].Lookup(ctx, encodedKey(v))
+					} else {
+						v = outputValue(v)
 					}
 
 					groupField["items"].([]map[string]interface{})[groupField["LastPropCount"].(int)][field.Name] = v
@@ -137,14 +139,24 @@ func output(ctx Context, id string, data Data, cacheLookup bool) map[string]inte
 				}
 			} else {
 				if doCacheLookup {
-					value, _ = Entities[field.Entity].Lookup(ctx, value.(string))
+					value, _ = Entities[field.Entity].Lookup(ctx, encodedKey(value))
+				} else {
+					value = outputValue(value)
 				}
 
 				output[field.GroupName].(map[string]interface{})[field.Name] = value
 			}
+		} else if field.Multiple && !doCacheLookup {
+			var values = make([]interface{}, 0, len(value.([]interface{})))
+			for _, v := range value.([]interface{}) {
+				values = append(values, outputValue(v))
+			}
+			output[field.Name] = values
 		} else {
 			if doCacheLookup {
-				value, _ = Entities[field.Entity].Lookup(ctx, value.(string))
+				value, _ = Entities[field.Entity].Lookup(ctx, encodedKey(value))
+			} else {
+				value = outputValue(value)
 			}
 
 			output[field.Name] = value
@@ -233,7 +245,7 @@ func (e *EntityDataHolder) appendValue(name string, value interface{}, trust Val
 // Safely appends value
 func (e *EntityDataHolder) appendFieldValue(field *Field, value interface{}, vc *ValueContext) error {
 	if !e.isNew && field.NoEdits {
-		return fmt.Errorf(ErrFieldEditPermissionDenied, field.datastoreFieldName)
+		return newFieldError(field, CodeEditDenied, ErrFieldEditPermissionDenied)
 	}
 
 	var v = value
@@ -241,7 +253,7 @@ func (e *EntityDataHolder) appendFieldValue(field *Field, value interface{}, vc
 	for _, fun := range field.fieldFunc {
 		v, err = fun(vc, v)
 		if err != nil {
-			return err
+			return toFieldError(field, err)
 		}
 	}
 
@@ -250,7 +262,7 @@ func (e *EntityDataHolder) appendFieldValue(field *Field, value interface{}, vc
 		return nil
 	}
 
-	return fmt.Errorf(ErrValueIsNil, field.datastoreFieldName)
+	return newFieldError(field, CodeInvalid, ErrValueIsNil)
 }
 
 // UNSAFE Appends value without any checks
@@ -277,13 +289,24 @@ func (e *EntityDataHolder) unsafeAppendFieldValue(field *Field, value interface{
 // load from datastore properties into Data map
 func (e *EntityDataHolder) Load(ps []datastore.Property) error {
 	/*e.data = map[*Field]interface{}{}*/
+	// with keepExistingValue only fields set before loading are kept; multiple fields load all their values
+	var existing = map[*Field]bool{}
+	if e.keepExistingValue {
+		for field := range e.data {
+			existing[field] = true
+		}
+	}
 	for _, prop := range ps {
 		if field, ok := e.Entity.fields[prop.Name]; ok {
 
 			if prop.Multiple != field.Multiple {
 				return fmt.Errorf(ErrDatastoreFieldPropertyMultiDismatch, prop.Name)
 			}
-			e.unsafeAppendFieldValue(field, prop.Value, nil, e.keepExistingValue)
+			// null is the same as no value
+			if (prop.Value == nil && !prop.Multiple) || existing[field] {
+				continue
+			}
+			e.unsafeAppendFieldValue(field, prop.Value, nil, false)
 		} else {
 			return fmt.Errorf(ErrNamedFieldNotDefined, prop.Name)
 		}
@@ -296,10 +319,8 @@ func (e *EntityDataHolder) Save() ([]datastore.Property, error) {
 	var ps []datastore.Property
 
 	// check if required fields are there
-	for _, field := range e.Entity.requiredFields {
-		if _, ok := e.data[field]; !ok {
-			return ps, fmt.Errorf(ErrFieldRequired, field.datastoreFieldName)
-		}
+	if err := e.Validate(); err != nil {
+		return ps, err
 	}
 
 	// create datastore property list
@@ -325,5 +346,12 @@ func (e *EntityDataHolder) Save() ([]datastore.Property, error) {
 		}
 	}
 
+	// live entities store null deletion time so that queries can filter trash
+	if e.Entity.SoftDelete {
+		if _, ok := e.data[e.Entity.fields[deletedAtFieldName]]; !ok {
+			ps = append(ps, datastore.Property{Name: deletedAtFieldName})
+		}
+	}
+
 	return ps, nil
 }
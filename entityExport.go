@@ -0,0 +1,253 @@
+package sdk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// export and import formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+const ErrFormatNotSupported string = "format '%s' is not supported"
+
+// exportPageSize is the number of entities queried at once while exporting
+const exportPageSize = 500
+
+// flatName is field name in exports; group fields are named as in flatOutput
+func flatName(field *Field) string {
+	if len(field.GroupName) != 0 {
+		return field.GroupName + strings.Title(field.Name)
+	}
+	return field.Name
+}
+
+// exportFields returns fields written by export in column order
+func (e *Entity) exportFields() []*Field {
+	var fields []*Field
+	for _, field := range e.sortedFields() {
+		if field.Json != NoJsonOutput {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+type exportEncoder interface {
+	Encode(h *EntityDataHolder) error
+	Flush() error
+}
+
+// Export writes all entities matching filters that are visible to the caller. CSV has a column for each
+// field; every value of multiple fields is written to its own row and rows of the same entity share _id.
+// NDJSON has an object per line with lists for multiple fields.
+func (e *Entity) Export(ctx Context, w io.Writer, format string, sort string, filters ...EntityQueryFilter) error {
+	var enc exportEncoder
+	switch format {
+	case FormatCSV:
+		enc = &csvEncoder{w: csv.NewWriter(w), fields: e.exportFields()}
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{enc: json.NewEncoder(w), fields: e.exportFields()}
+	default:
+		return fmt.Errorf(ErrFormatNotSupported, format)
+	}
+
+	var cursor string
+	for {
+		hs, nextCursor, err := e.Query(ctx, sort, exportPageSize, 0, cursor, filters...)
+		if err != nil {
+			return err
+		}
+		for _, h := range hs {
+			if err := enc.Encode(h); err != nil {
+				return err
+			}
+		}
+		if len(nextCursor) == 0 {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	return enc.Flush()
+}
+
+type csvEncoder struct {
+	w             *csv.Writer
+	fields        []*Field
+	headerWritten bool
+}
+
+func (c *csvEncoder) header() error {
+	c.headerWritten = true
+	var header = []string{"_id"}
+	for _, field := range c.fields {
+		header = append(header, flatName(field))
+	}
+	return c.w.Write(header)
+}
+
+func (c *csvEncoder) Encode(h *EntityDataHolder) error {
+	if !c.headerWritten {
+		if err := c.header(); err != nil {
+			return err
+		}
+	}
+
+	var rows = [][]string{c.row(h.Id)}
+	for i, field := range c.fields {
+		value, ok := h.data[field]
+		if !ok {
+			continue
+		}
+		if !field.Multiple {
+			rows[0][i+1] = csvValue(value)
+			continue
+		}
+		for j, v := range value.([]interface{}) {
+			if j == len(rows) {
+				rows = append(rows, c.row(h.Id))
+			}
+			rows[j][i+1] = csvValue(v)
+		}
+	}
+	return c.w.WriteAll(rows)
+}
+
+func (c *csvEncoder) row(id string) []string {
+	var row = make([]string, len(c.fields)+1)
+	row[0] = id
+	return row
+}
+
+func (c *csvEncoder) Flush() error {
+	if !c.headerWritten {
+		if err := c.header(); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvValue formats values so that field types read them back on import
+func csvValue(v interface{}) string {
+	switch val := outputValue(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case map[string]interface{}:
+		// geo point
+		if lat, ok := val["lat"]; ok {
+			return fmt.Sprintf("%v,%v", lat, val["lng"])
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+type ndjsonEncoder struct {
+	enc    *json.Encoder
+	fields []*Field
+}
+
+func (n *ndjsonEncoder) Encode(h *EntityDataHolder) error {
+	var out = map[string]interface{}{"_id": h.Id}
+	for _, field := range n.fields {
+		value, ok := h.data[field]
+		if !ok {
+			continue
+		}
+		if !field.Multiple {
+			out[flatName(field)] = outputValue(value)
+			continue
+		}
+		var values = []interface{}{}
+		for _, v := range value.([]interface{}) {
+			values = append(values, outputValue(v))
+		}
+		out[flatName(field)] = values
+	}
+	return n.enc.Encode(out)
+}
+
+func (n *ndjsonEncoder) Flush() error {
+	return nil
+}
+
+// exportWriter sets response headers on first write so that errors before any output are printed as usual
+type exportWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	written     bool
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	if !ew.written {
+		ew.written = true
+		ew.w.Header().Set("Content-Type", ew.contentType)
+		ew.w.Header().Set("Content-Disposition", "attachment; filename=\""+ew.filename+"\"")
+	}
+	return ew.w.Write(p)
+}
+
+func (e *Entity) handleExport() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+
+		q := r.URL.Query()
+
+		format := q.Get("format")
+		if len(format) == 0 {
+			format = FormatCSV
+		}
+		var ew = &exportWriter{w: w, filename: e.Name + "." + format}
+		switch format {
+		case FormatCSV:
+			ew.contentType = "text/csv; charset=utf-8"
+		case FormatNDJSON:
+			ew.contentType = "application/x-ndjson"
+		default:
+			ctx.PrintError(w, fmt.Errorf(ErrFormatNotSupported, format), http.StatusBadRequest)
+			return
+		}
+
+		filters, err := e.ParseQueryFilters(q)
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		sort, err := e.ParseQuerySort(q.Get("sort"))
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		err = e.Export(ctx, ew, format, sort, filters...)
+		if err != nil {
+			if ew.written {
+				// response is already partly sent
+				logErrorf(ctx.Context, "%v", err.Error())
+				return
+			}
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+		}
+	}
+}
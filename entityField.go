@@ -28,8 +28,22 @@ type Field struct {
 
 	Type FieldType `json:"type"` // for special backend functions; file - saves multipart file and returns url
 
-	Entity string `json:"-"`      // if set, value should be encoded entity key
-	Lookup bool   `json:"lookup"` // if true, looks up entity value on output
+	// constraints for typed fields
+	Min       *float64 `json:"min"`       // int, float
+	Max       *float64 `json:"max"`       // int, float
+	MinLength int      `json:"minLength"` // text, html, enum
+	MaxLength int      `json:"maxLength"` // text, html, enum; zero means no limit
+	Enum      []string `json:"enum"`      // enum; allowed values
+	Precision int      `json:"precision"` // float; number of decimals values are rounded to
+
+	Entity   string `json:"-"`        // if set, value should be encoded entity key
+	Lookup   bool   `json:"lookup"`   // if true, looks up entity value on output
+	OnDelete string `json:"onDelete"` // what happens to the value when referenced entity is deleted; see OnDeleteRestrict
+
+	Unique bool `json:"unique"` // value can't be used by another entity of the same kind; multiple field values each
+
+	Source    string `json:"source"`    // slug; name of field the slug is generated from
+	Redirects bool   `json:"redirects"` // slug; slug follows changes of Source and previous slugs redirect to the entity
 
 	DefaultValue interface{}                   `json:"defaultValue"`
 	ValueFunc    func() interface{}            `json:"-"`
@@ -58,11 +72,18 @@ type Meta map[string]interface{}
 const (
 	FileType  FieldType = "file"
 	ImageType FieldType = "image"
-	/*ImageType FieldType = "image"
-	TextType FieldType = "text"
-	HTMLTemplateType FieldType = "html"
-	DateTimeType  FieldType = "datetime"
-	BooleanType  FieldType = "bool"*/
+
+	// typed fields convert input values and check constraints
+	IntType      FieldType = "int"      // int64
+	FloatType    FieldType = "float"    // float64
+	BoolType     FieldType = "bool"     // bool
+	DateTimeType FieldType = "datetime" // time.Time; RFC 3339 or html date input
+	TextType     FieldType = "text"     // long string; not indexed
+	HTMLType     FieldType = "html"     // html string; not indexed
+	GeoPointType FieldType = "geopoint" // appengine.GeoPoint; {"lat": 0, "lng": 0} or "lat,lng"
+	KeyType      FieldType = "key"      // *datastore.Key; encoded key of Entity if set
+	EnumType     FieldType = "enum"     // string, one of Enum
+	SlugType     FieldType = "slug"     // unique URL-safe string; generated from Source on add
 )
 
 type GroupEntity struct {
@@ -0,0 +1,252 @@
+package sdk
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// dateTimeLayouts are accepted by DateTimeType fields; the last two are what html date inputs send
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// typeFunc returns function converting values to the field type and checking field constraints.
+// Constraints are not checked for values with High trust.
+func typeFunc(field *Field) func(c *ValueContext, v interface{}) (interface{}, error) {
+	var convert func(v interface{}) (interface{}, bool)
+
+	switch field.Type {
+	case IntType:
+		convert = toInt
+	case FloatType:
+		convert = func(v interface{}) (interface{}, bool) {
+			f, ok := toFloat(v)
+			if ok && field.Precision > 0 {
+				p := math.Pow(10, float64(field.Precision))
+				f = math.Round(f*p) / p
+			}
+			return f, ok
+		}
+	case BoolType:
+		convert = toBool
+	case DateTimeType:
+		convert = toDateTime
+	case TextType, HTMLType, EnumType:
+		convert = func(v interface{}) (interface{}, bool) {
+			s, ok := v.(string)
+			return s, ok
+		}
+	case GeoPointType:
+		convert = toGeoPoint
+	case SlugType:
+		convert = toSlug
+	case KeyType:
+		convert = func(v interface{}) (interface{}, bool) {
+			key, ok := toKey(v)
+			if ok && len(field.Entity) > 0 && key.Kind() != field.Entity {
+				return nil, false
+			}
+			return key, ok
+		}
+	default:
+		return nil
+	}
+
+	return func(c *ValueContext, v interface{}) (interface{}, error) {
+		value, ok := convert(v)
+		if !ok {
+			err := newFieldError(c.Field, CodeType, ErrFieldValueTypeNotValid)
+			err.Params = map[string]interface{}{"type": field.Type}
+			return v, err
+		}
+		if c.Trust == High {
+			return value, nil
+		}
+		if !field.satisfies(value) {
+			err := newFieldError(c.Field, CodeInvalid, ErrFieldValueNotValid)
+			err.Params = field.constraints()
+			return value, err
+		}
+		return value, nil
+	}
+}
+
+// satisfies checks min/max, length and enum constraints
+func (f *Field) satisfies(value interface{}) bool {
+	switch val := value.(type) {
+	case int64:
+		return f.inRange(float64(val))
+	case float64:
+		return f.inRange(val)
+	case string:
+		n := utf8.RuneCountInString(val)
+		if n < f.MinLength || (f.MaxLength > 0 && n > f.MaxLength) {
+			return false
+		}
+		if f.Type == EnumType {
+			for _, e := range f.Enum {
+				if e == val {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Field) inRange(v float64) bool {
+	return (f.Min == nil || v >= *f.Min) && (f.Max == nil || v <= *f.Max)
+}
+
+func toInt(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	case float64:
+		// json numbers
+		if val != math.Trunc(val) {
+			return nil, false
+		}
+		return int64(val), true
+	case json.Number:
+		i, err := val.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		return i, err == nil
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int64:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case json.Number:
+		f, err := val.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toBool(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	case string:
+		// checkbox inputs send "on"
+		if val == "on" {
+			return true, true
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		return b, err == nil
+	}
+	return nil, false
+}
+
+func toDateTime(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case string:
+		val = strings.TrimSpace(val)
+		for _, layout := range dateTimeLayouts {
+			if tm, err := time.Parse(layout, val); err == nil {
+				return tm, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// toGeoPoint accepts GeoPoint, {"lat": 0, "lng": 0} and "lat,lng"
+func toGeoPoint(v interface{}) (interface{}, bool) {
+	var pt appengine.GeoPoint
+	var ok bool
+
+	switch val := v.(type) {
+	case appengine.GeoPoint:
+		pt, ok = val, true
+	case *appengine.GeoPoint:
+		if val != nil {
+			pt, ok = *val, true
+		}
+	case map[string]interface{}:
+		var latOk, lngOk bool
+		pt.Lat, latOk = toFloat(val["lat"])
+		pt.Lng, lngOk = toFloat(val["lng"])
+		ok = latOk && lngOk
+	case string:
+		if parts := strings.Split(val, ","); len(parts) == 2 {
+			var latOk, lngOk bool
+			pt.Lat, latOk = toFloat(parts[0])
+			pt.Lng, lngOk = toFloat(parts[1])
+			ok = latOk && lngOk
+		}
+	}
+
+	if !ok || !pt.Valid() {
+		return nil, false
+	}
+	return pt, true
+}
+
+func toKey(v interface{}) (*datastore.Key, bool) {
+	switch val := v.(type) {
+	case *datastore.Key:
+		return val, val != nil
+	case string:
+		key, err := datastore.DecodeKey(val)
+		return key, err == nil
+	}
+	return nil, false
+}
+
+// outputValue converts stored values to their JSON representation
+func outputValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case *datastore.Key:
+		if val == nil {
+			return nil
+		}
+		return val.Encode()
+	case appengine.GeoPoint:
+		return map[string]interface{}{
+			"lat": val.Lat,
+			"lng": val.Lng,
+		}
+	}
+	return value
+}
+
+// encodedKey returns encoded key of lookup field value which is stored either as a string or a key
+func encodedKey(value interface{}) string {
+	if key, ok := value.(*datastore.Key); ok {
+		if key == nil {
+			return ""
+		}
+		return key.Encode()
+	}
+	s, _ := value.(string)
+	return s
+}
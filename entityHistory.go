@@ -0,0 +1,356 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// revision actions
+const (
+	RevisionAdd     = "add"
+	RevisionPut     = "put"
+	RevisionEdit    = "edit"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+	RevisionPurge   = "purge"
+)
+
+var (
+	ErrRevisionNotRevertible = errors.New("revision can't be reverted")
+	ErrRevisionAmbiguous     = errors.New("entity has more than one revision with this number")
+)
+
+// lastRevisionKeyName names revision key that keeps the last revision of purged entity
+const lastRevisionKeyName = "last"
+
+// Change holds field value before and after the revision; nil means the field had no value
+type Change struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// initHistory defines kind revisions are stored in. Revisions are children of the changed entity keyed by
+// their number; actor and time are kept in _createdBy and _createdAt.
+func (e *Entity) initHistory() error {
+	e.history = &Entity{
+		Name: "_" + e.Name + "History",
+		Fields: []*Field{
+			{Name: "record", Type: KeyType, Entity: e.Name},
+			{Name: "rev", Type: IntType},
+			{Name: "action"},
+			{Name: "role"},
+			{Name: "changes", Type: TextType},
+			{Name: "snapshot", Type: TextType, Json: NoJsonOutput},
+		},
+	}
+	_, err := e.history.init()
+	return err
+}
+
+// stored loads entity as it is saved, without prepared values
+func (e *Entity) stored(c context.Context, key *datastore.Key) (*EntityDataHolder, error) {
+	var h = &EntityDataHolder{Entity: e, data: Data{}}
+	err := store.Get(c, key, h)
+	return h, err
+}
+
+// addRevision stores entity change if History is enabled; before is nil for added and after for purged entity
+func (e *Entity) addRevision(c context.Context, ctx Context, key *datastore.Key, rev int64, action string, before, after map[string]interface{}) error {
+	if e.history == nil {
+		return nil
+	}
+
+	changes, err := json.Marshal(diff(before, after))
+	if err != nil {
+		return err
+	}
+
+	var h = e.history.New(ctx)
+	h.data[e.history.fields["record"]] = key
+	h.data[e.history.fields["rev"]] = rev
+	h.data[e.history.fields["action"]] = action
+	h.data[e.history.fields["role"]] = string(ctx.Role)
+	h.data[e.history.fields["changes"]] = string(changes)
+	if after != nil {
+		snapshot, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		h.data[e.history.fields["snapshot"]] = string(snapshot)
+	}
+
+	if _, err = store.Put(c, datastore.NewKey(c, e.history.Name, "", rev, key), h); err != nil {
+		return err
+	}
+
+	// entity added again with the same key continues numbering after purge
+	if action == RevisionPurge {
+		var last = &EntityDataHolder{Entity: e.history, data: Data{e.history.fields["rev"]: rev}}
+		_, err = store.Put(c, datastore.NewKey(c, e.history.Name, lastRevisionKeyName, 0, key), last)
+	}
+	return err
+}
+
+// firstRevision returns number of the first revision of entity added with key; it is 1 unless an entity with
+// the same key was purged
+func (e *Entity) firstRevision(c context.Context, key *datastore.Key) (int64, error) {
+	if e.history == nil || key.Incomplete() {
+		return 1, nil
+	}
+	var last = &EntityDataHolder{Entity: e.history, data: Data{}}
+	err := store.Get(c, datastore.NewKey(c, e.history.Name, lastRevisionKeyName, 0, key), last)
+	if err == datastore.ErrNoSuchEntity {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rev, _ := last.data[e.history.fields["rev"]].(int64)
+	return rev + 1, nil
+}
+
+// diff compares entity documents; version is left out as it changes with every revision
+func diff(before, after map[string]interface{}) map[string]*Change {
+	var changes = map[string]*Change{}
+	for name, value := range before {
+		changes[name] = &Change{Old: value, New: after[name]}
+	}
+	for name, value := range after {
+		if c, ok := changes[name]; ok {
+			c.New = value
+		} else {
+			changes[name] = &Change{New: value}
+		}
+	}
+	for name, c := range changes {
+		if name == versionFieldName || jsonEqual(c.Old, c.New) {
+			delete(changes, name)
+		}
+	}
+	return changes
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// Revisions returns revisions of entity, last first. Datastore needs composite index on record and -rev.
+func (e *Entity) Revisions(ctx Context, key *datastore.Key, limit int, cursor string) ([]*EntityDataHolder, string, error) {
+	var hs []*EntityDataHolder
+	if e.history == nil {
+		return hs, "", nil
+	}
+	if !ctx.HasScope(e, ScopeRead) {
+		return hs, "", ErrNotAuthorized
+	}
+	if e.Private {
+		h, err := e.stored(ctx.Context, key)
+		if err != nil {
+			return hs, "", err
+		}
+		if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
+			return hs, "", ErrNotAuthorized
+		}
+	}
+
+	var n int
+	t := store.Run(ctx.Context, &StoreQuery{
+		Kind:    e.history.Name,
+		Filters: []EntityQueryFilter{{Name: "record", Operator: "=", Value: key}},
+		Orders:  []string{"-rev"},
+		Limit:   limit,
+		Cursor:  cursor,
+	})
+	for {
+		var h = &EntityDataHolder{Entity: e.history, data: Data{}}
+		revKey, err := t.Next(h)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return hs, "", err
+		}
+		n++
+		h.Id = revKey.Encode()
+		hs = append(hs, h)
+	}
+
+	var nextCursor string
+	if limit != 0 && n == limit {
+		c, err := t.Cursor()
+		if err != nil {
+			return hs, "", err
+		}
+		nextCursor = c
+	}
+	return hs, nextCursor, nil
+}
+
+// revision returns revision rev of entity. Revisions stored before they were keyed by number can repeat
+// numbers of purged entities; ErrRevisionAmbiguous is returned for them.
+func (e *Entity) revision(ctx Context, key *datastore.Key, rev int64) (*EntityDataHolder, error) {
+	var h *EntityDataHolder
+	t := store.Run(ctx.Context, &StoreQuery{
+		Kind: e.history.Name,
+		Filters: []EntityQueryFilter{
+			{Name: "record", Operator: "=", Value: key},
+			{Name: "rev", Operator: "=", Value: rev},
+		},
+		Limit: 2,
+	})
+	for {
+		var next = &EntityDataHolder{Entity: e.history, data: Data{}}
+		revKey, err := t.Next(next)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			return nil, ErrRevisionAmbiguous
+		}
+		next.Id = revKey.Encode()
+		h = next
+	}
+	if h == nil {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return h, nil
+}
+
+// Revert edits entity back to the values it had after revision rev. Fields that can't be edited keep their
+// values. Revert is stored as a new revision.
+func (e *Entity) Revert(ctx Context, key *datastore.Key, rev int64) (*EntityDataHolder, error) {
+	if e.history == nil {
+		return nil, ErrRevisionNotRevertible
+	}
+
+	r, err := e.revision(ctx, key, rev)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, ok := r.data[e.history.fields["snapshot"]].(string)
+	if !ok {
+		return nil, ErrRevisionNotRevertible
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(snapshot), &doc); err != nil {
+		return nil, err
+	}
+
+	// null clears fields that had no value
+	var patch = MergePatch{}
+	for name, field := range e.fields {
+		if field.NoEdits || field.Json == NoJsonOutput {
+			continue
+		}
+		patch[name] = doc[name]
+	}
+	return e.patch(ctx, key, patch, RevisionRevert)
+}
+
+// revisionOutput outputs revision with decoded changes
+func (e *Entity) revisionOutput(ctx Context, h *EntityDataHolder) map[string]interface{} {
+	var out = h.Output(ctx)
+	var changes map[string]*Change
+	if s, ok := h.data[e.history.fields["changes"]].(string); ok {
+		if err := json.Unmarshal([]byte(s), &changes); err == nil {
+			out["changes"] = changes
+		}
+	}
+	return out
+}
+
+func (e *Entity) handleHistory() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+		vars := mux.Vars(r)
+
+		ctx, key, err := e.DecodeKey(ctx, vars["encodedKey"])
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		q := r.URL.Query()
+		var limit = 0
+		if limitStr := q.Get("limit"); len(limitStr) != 0 {
+			limit, _ = strconv.Atoi(limitStr)
+		}
+
+		hs, nextCursor, err := e.Revisions(ctx, key, limit, q.Get("cursor"))
+		if err == ErrInvalidCursor {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		var data []map[string]interface{}
+		for _, h := range hs {
+			data = append(data, e.revisionOutput(ctx, h))
+		}
+
+		ctx.Print(w, map[string]interface{}{
+			"data":       data,
+			"count":      len(data),
+			"nextCursor": nextCursor,
+		})
+	}
+}
+
+func (e *Entity) handleRevert() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r).WithIfMatch(r.Header.Get("If-Match"))
+		vars := mux.Vars(r)
+
+		ctx, key, err := e.DecodeKey(ctx, vars["encodedKey"])
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		rev, err := strconv.ParseInt(vars["rev"], 10, 64)
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		h, err := e.Revert(ctx, key, rev)
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
+		if err == ErrRevisionNotRevertible {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err == ErrRevisionAmbiguous {
+			ctx.PrintError(w, err, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, errorStatus(err))
+			return
+		}
+
+		w.Header().Set("ETag", h.ETag())
+		ctx.Print(w, h.Output(ctx))
+	}
+}
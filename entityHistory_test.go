@@ -0,0 +1,92 @@
+package sdk
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRevisionsAfterPurge(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "historyItem", History: true, Fields: []*Field{{Name: "name"}}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	key := datastore.NewKey(ctx.Context, e.Name, "named", 0, nil)
+
+	add := func(name string) {
+		h, err := e.FromMap(ctx, map[string]interface{}{"name": name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = e.Add(ctx, key, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("first")
+	if _, err := e.Patch(ctx, key, MergePatch{"name": "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Purge(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// entity added again with the same key doesn't reuse revision numbers
+	add("second")
+	hs, _, err := e.Revisions(ctx, key, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revs []int64
+	for _, h := range hs {
+		revs = append(revs, h.data[e.history.fields["rev"]].(int64))
+	}
+	if !equalNumbers(revs, 4, 3, 2, 1) {
+		t.Fatalf("revisions are %v, want [4 3 2 1]", revs)
+	}
+	h, err := e.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version() != 4 {
+		t.Fatalf("version is %d, want 4", h.Version())
+	}
+
+	if h, err = e.Revert(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if name := h.Get(ctx, "name"); name != "first" {
+		t.Fatalf("name is %v after revert, want first", name)
+	}
+}
+
+func TestRevertAmbiguousRevision(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "historyLegacy", History: true, Fields: []*Field{{Name: "name"}}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a"})
+
+	// revisions stored before they were keyed by number
+	for _, name := range []string{"old", "older"} {
+		if err := e.addRevision(ctx.Context, ctx, key, 5, RevisionEdit, nil, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+		revKey := datastore.NewKey(ctx.Context, e.history.Name, "", 5, key)
+		r, err := e.history.stored(ctx.Context, revKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Delete(ctx.Context, revKey); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Put(ctx.Context, datastore.NewIncompleteKey(ctx.Context, e.history.Name, key), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := e.Revert(ctx, key, 5); err != ErrRevisionAmbiguous {
+		t.Fatalf("revert returned %v, want ErrRevisionAmbiguous", err)
+	}
+}
@@ -0,0 +1,159 @@
+package sdk
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// hook events and hook function types
+const (
+	HookValidate     = "validate"     // func(Context, *EntityDataHolder) error; return FieldError or ValidationError
+	HookBeforeAdd    = "beforeAdd"    // func(Context, *EntityDataHolder) error
+	HookBeforeEdit   = "beforeEdit"   // func(Context, *EntityDataHolder) error
+	HookBeforeWrite  = "beforeWrite"  // func(Context, *EntityDataHolder) error; runs after add and edit hooks
+	HookAfterWrite   = "afterWrite"   // func(Context, *EntityDataHolder) error
+	HookAfterRead    = "afterRead"    // func(Context, *EntityDataHolder) error
+	HookBeforeDelete = "beforeDelete" // func(Context, *datastore.Key) error; runs before trash and purge
+	HookAfterDelete  = "afterDelete"  // func(Context, *datastore.Key) error
+	HookBeforeQuery  = "beforeQuery"  // func(Context, *QueryParams) error
+)
+
+const ErrHookNotValid string = "hook of event '%s' has wrong type"
+
+// QueryParams are passed to HookBeforeQuery hooks; hooks can change them, e.g. add filters
+type QueryParams struct {
+	Sort    string
+	Limit   int
+	Offset  int
+	Cursor  string
+	Filters []EntityQueryFilter
+	Deleted bool // trash is queried
+}
+
+type hook struct {
+	order int
+	fn    interface{}
+}
+
+// AddHook registers hook function of event. Hooks run in ascending order and On* fields run with order zero,
+// before hooks added with the same order. The first error stops the operation and is returned.
+func (e *Entity) AddHook(event string, order int, fn interface{}) error {
+	var ok bool
+	switch event {
+	case HookValidate, HookBeforeAdd, HookBeforeEdit, HookBeforeWrite, HookAfterWrite, HookAfterRead:
+		_, ok = fn.(func(Context, *EntityDataHolder) error)
+	case HookBeforeDelete, HookAfterDelete:
+		_, ok = fn.(func(Context, *datastore.Key) error)
+	case HookBeforeQuery:
+		_, ok = fn.(func(Context, *QueryParams) error)
+	}
+	if !ok {
+		return fmt.Errorf(ErrHookNotValid, event)
+	}
+
+	if e.hooks == nil {
+		e.hooks = map[string][]hook{}
+	}
+	hs := append(e.hooks[event], hook{order: order, fn: fn})
+	sort.SliceStable(hs, func(i, j int) bool {
+		return hs[i].order < hs[j].order
+	})
+	e.hooks[event] = hs
+	return nil
+}
+
+// fieldHook returns On* field of event or nil
+func (e *Entity) fieldHook(event string) interface{} {
+	var holderHook func(c Context, h *EntityDataHolder) error
+	var keyHook func(c Context, key *datastore.Key) error
+	switch event {
+	case HookValidate:
+		holderHook = e.OnValidate
+	case HookBeforeAdd:
+		holderHook = e.OnBeforeAdd
+	case HookBeforeEdit:
+		holderHook = e.OnBeforeEdit
+	case HookBeforeWrite:
+		holderHook = e.OnBeforeWrite
+	case HookAfterWrite:
+		holderHook = e.OnAfterWrite
+	case HookAfterRead:
+		holderHook = e.OnAfterRead
+	case HookBeforeDelete:
+		keyHook = e.OnBeforeDelete
+	case HookAfterDelete:
+		keyHook = e.OnAfterDelete
+	case HookBeforeQuery:
+		if e.OnBeforeQuery != nil {
+			return e.OnBeforeQuery
+		}
+	}
+	if holderHook != nil {
+		return holderHook
+	}
+	if keyHook != nil {
+		return keyHook
+	}
+	return nil
+}
+
+// hooksOf returns hooks of event in order they run
+func (e *Entity) hooksOf(event string) []interface{} {
+	var fns []interface{}
+	field := e.fieldHook(event)
+	for _, h := range e.hooks[event] {
+		if field != nil && h.order >= 0 {
+			fns = append(fns, field)
+			field = nil
+		}
+		fns = append(fns, h.fn)
+	}
+	if field != nil {
+		fns = append(fns, field)
+	}
+	return fns
+}
+
+// hook runs hooks of entity event
+func (e *Entity) hook(event string, ctx Context, h *EntityDataHolder) error {
+	for _, fn := range e.hooksOf(event) {
+		if err := fn.(func(Context, *EntityDataHolder) error)(ctx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyHook runs hooks of delete event
+func (e *Entity) keyHook(event string, ctx Context, key *datastore.Key) error {
+	for _, fn := range e.hooksOf(event) {
+		if err := fn.(func(Context, *datastore.Key) error)(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Entity) queryHook(ctx Context, q *QueryParams) error {
+	for _, fn := range e.hooksOf(HookBeforeQuery) {
+		if err := fn.(func(Context, *QueryParams) error)(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beforeWrite runs validate hooks, hooks of event if set and before write hooks
+func (e *Entity) beforeWrite(ctx Context, event string, h *EntityDataHolder) error {
+	if err := e.hook(HookValidate, ctx, h); err != nil {
+		return err
+	}
+	if len(event) != 0 {
+		if err := e.hook(event, ctx, h); err != nil {
+			return err
+		}
+	}
+	return e.hook(HookBeforeWrite, ctx, h)
+}
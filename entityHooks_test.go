@@ -0,0 +1,80 @@
+package sdk
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestHooks(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTestEntity(t, "hookItem")
+
+	var calls []string
+	holderHook := func(name string) func(Context, *EntityDataHolder) error {
+		return func(ctx Context, h *EntityDataHolder) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	e.OnBeforeWrite = holderHook("field")
+	for _, h := range []struct {
+		event string
+		order int
+		name  string
+	}{
+		{HookBeforeWrite, 5, "late"},
+		{HookBeforeWrite, -1, "early"},
+		{HookBeforeWrite, 0, "zero"},
+		{HookBeforeAdd, 10, "add"},
+		{HookValidate, 0, "validate"},
+	} {
+		if err := e.AddHook(h.event, h.order, holderHook(h.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.AddHook(HookBeforeDelete, 0, holderHook("wrong")); err == nil {
+		t.Fatal("hook of wrong type is added")
+	}
+
+	added := addTestItem(t, ctx, e, map[string]interface{}{"name": "a"})
+	want := []string{"validate", "add", "early", "field", "zero", "late"}
+	if len(calls) != len(want) {
+		t.Fatalf("hooks ran %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("hooks ran %v, want %v", calls, want)
+		}
+	}
+
+	// the first error stops the write
+	errRejected := errors.New("rejected")
+	e.AddHook(HookBeforeAdd, 0, func(ctx Context, h *EntityDataHolder) error {
+		return errRejected
+	})
+	h, err := e.FromMap(ctx, map[string]interface{}{"name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key := e.NewIncompleteKey(ctx)
+	if _, err = e.Add(ctx, key, h); err != errRejected {
+		t.Fatalf("add returned %v, want hook error", err)
+	}
+	if hs, _, _ := e.Query(ctx, "", 0, 0, ""); len(hs) != 1 {
+		t.Fatalf("query returned %d entities, want 1", len(hs))
+	}
+
+	var deleted *datastore.Key
+	e.AddHook(HookAfterDelete, 0, func(ctx Context, key *datastore.Key) error {
+		deleted = key
+		return nil
+	})
+	if err = e.Delete(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+	if deleted == nil || !deleted.Equal(added) {
+		t.Fatalf("after delete hook got %v, want %v", deleted, added)
+	}
+}
@@ -0,0 +1,303 @@
+package sdk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/appengine/datastore"
+)
+
+const ErrImportColumnNotDefined string = "column '%s' is not a field"
+
+type ImportOptions struct {
+	Format string // FormatCSV or FormatNDJSON
+	DryRun bool   // only validates rows
+	Upsert bool   // rows with _id edit the entity with that key or add it if it doesn't exist
+}
+
+type ImportResult struct {
+	Rows     int            `json:"rows"`
+	Imported int            `json:"imported"`
+	DryRun   bool           `json:"dryRun"`
+	Errors   []*ImportError `json:"errors,omitempty"`
+}
+
+// ImportError describes rejected row; row is line number of the entity in CSV or NDJSON input
+type ImportError struct {
+	Row     int                    `json:"row"`
+	Id      string                 `json:"id,omitempty"`
+	Message string                 `json:"message"`
+	Errors  map[string]*FieldError `json:"errors,omitempty"`
+}
+
+// importRecord is one entity read from import input
+type importRecord struct {
+	row    int
+	id     string
+	values map[string]interface{} // input by datastore field name as accepted by FromMap
+	err    error                  // row could not be read
+}
+
+// importColumns maps export column names and datastore field names to fields. Special and hidden fields are
+// mapped to nil and skipped.
+func (e *Entity) importColumns() map[string]*Field {
+	var columns = map[string]*Field{"_id": nil}
+	for name, field := range e.fields {
+		if field.isSpecialField || field.Json == NoJsonOutput {
+			columns[name] = nil
+			columns[flatName(field)] = nil
+			continue
+		}
+		columns[name] = field
+		columns[flatName(field)] = field
+	}
+	return columns
+}
+
+// Import reads entities exported with Export or written in the same format and validates every one with
+// FromMap. Rows failing validation are reported and the rest are saved unless DryRun is set. Rows adding
+// entities need add scope and rows editing them edit scope.
+func (e *Entity) Import(ctx Context, r io.Reader, opt ImportOptions) (*ImportResult, error) {
+	if !ctx.HasScope(e, ScopeAdd) && !(opt.Upsert && ctx.HasScope(e, ScopeEdit)) {
+		return nil, ErrNotAuthorized
+	}
+
+	var result = &ImportResult{DryRun: opt.DryRun}
+	var add = func(rec *importRecord) {
+		result.Rows++
+		err := e.importRecord(ctx, rec, opt)
+		if err == nil {
+			result.Imported++
+			return
+		}
+		var ierr = &ImportError{Row: rec.row, Id: rec.id, Message: err.Error(), Errors: fieldErrors(err)}
+		result.Errors = append(result.Errors, ierr)
+	}
+
+	var err error
+	switch opt.Format {
+	case FormatCSV:
+		err = e.readCSV(r, add)
+	case FormatNDJSON:
+		err = e.readNDJSON(r, add)
+	default:
+		err = fmt.Errorf(ErrFormatNotSupported, opt.Format)
+	}
+	return result, err
+}
+
+func (e *Entity) importRecord(ctx Context, rec *importRecord, opt ImportOptions) error {
+	if rec.err != nil {
+		return rec.err
+	}
+
+	h, err := e.FromMap(ctx, rec.values)
+
+	var key *datastore.Key
+	if opt.Upsert && len(rec.id) != 0 {
+		var kerr error
+		if _, key, kerr = e.DecodeKey(ctx, rec.id); kerr != nil || key.Kind() != e.Name {
+			return &FieldError{Field: "_id", Code: CodeInvalid, Message: fmt.Sprintf(ErrFieldValueNotValid, "_id")}
+		}
+		// values missing from the row are kept when entity exists
+		err = partialInput(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		if !ctx.HasScope(e, ScopeAdd) {
+			return ErrNotAuthorized
+		}
+		if opt.DryRun {
+			return nil
+		}
+		_, key = e.NewIncompleteKey(ctx)
+		_, err = e.Add(ctx, key, h)
+		return err
+	}
+
+	if opt.DryRun || !ctx.HasScope(e, ScopeEdit) {
+		var scope = ScopeEdit
+		if _, err = e.stored(ctx.Context, key); err == datastore.ErrNoSuchEntity {
+			scope = ScopeAdd
+		} else if err != nil {
+			return err
+		}
+		if !ctx.HasScope(e, scope) {
+			return ErrNotAuthorized
+		}
+		if opt.DryRun {
+			return nil
+		}
+	}
+
+	if ctx.HasScope(e, ScopeEdit) {
+		_, err = e.Edit(ctx, key, h)
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	}
+	if h, err = e.FromMap(ctx, rec.values); err != nil {
+		return err
+	}
+	_, err = e.Add(ctx, key, h)
+	return err
+}
+
+// readCSV reads rows to records; rows with the same _id as the row before add values to its record
+func (e *Entity) readCSV(r io.Reader, f func(rec *importRecord)) error {
+	var reader = csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var columns = e.importColumns()
+	var fields = make([]*Field, len(header))
+	var idColumn = -1
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		field, ok := columns[name]
+		if !ok {
+			return fmt.Errorf(ErrImportColumnNotDefined, name)
+		}
+		if name == "_id" {
+			idColumn = i
+		}
+		fields[i] = field
+	}
+
+	var rec *importRecord
+	var row = 1
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			if rec != nil {
+				f(rec)
+			}
+			f(&importRecord{row: row, err: err})
+			rec = nil
+			continue
+		}
+
+		var id string
+		if idColumn >= 0 && idColumn < len(cells) {
+			id = strings.TrimSpace(cells[idColumn])
+		}
+		if rec == nil || len(id) == 0 || id != rec.id {
+			if rec != nil {
+				f(rec)
+			}
+			rec = &importRecord{row: row, id: id, values: map[string]interface{}{}}
+		}
+
+		for i, cell := range cells {
+			if i >= len(fields) || fields[i] == nil || len(cell) == 0 {
+				continue
+			}
+			var field = fields[i]
+			if field.Multiple {
+				values, _ := rec.values[field.datastoreFieldName].([]interface{})
+				rec.values[field.datastoreFieldName] = append(values, cell)
+			} else if _, ok := rec.values[field.datastoreFieldName]; !ok {
+				rec.values[field.datastoreFieldName] = cell
+			}
+		}
+	}
+	if rec != nil {
+		f(rec)
+	}
+	return nil
+}
+
+// readNDJSON reads an object per line; unknown keys are reported as errors of the row
+func (e *Entity) readNDJSON(r io.Reader, f func(rec *importRecord)) error {
+	var columns = e.importColumns()
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var row int
+	for scanner.Scan() {
+		row++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			f(&importRecord{row: row, err: err})
+			continue
+		}
+
+		var rec = &importRecord{row: row, values: map[string]interface{}{}}
+		for name, value := range obj {
+			field, ok := columns[name]
+			if !ok {
+				rec.err = fmt.Errorf(ErrImportColumnNotDefined, name)
+				break
+			}
+			if name == "_id" {
+				rec.id, _ = value.(string)
+				continue
+			}
+			if field == nil || value == nil {
+				continue
+			}
+			rec.values[field.datastoreFieldName] = value
+		}
+		f(rec)
+	}
+	return scanner.Err()
+}
+
+func (e *Entity) handleImport() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+
+		q := r.URL.Query()
+		var opt = ImportOptions{
+			Format: q.Get("format"),
+			DryRun: q.Get("dryRun") == "true",
+			Upsert: q.Get("upsert") == "true",
+		}
+		if len(opt.Format) == 0 {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			switch mediaType {
+			case "application/x-ndjson":
+				opt.Format = FormatNDJSON
+			default:
+				opt.Format = FormatCSV
+			}
+		}
+
+		result, err := e.Import(ctx, r.Body, opt)
+		if err == ErrNotAuthorized {
+			ctx.PrintError(w, err, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		ctx.Print(w, result)
+	}
+}
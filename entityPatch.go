@@ -0,0 +1,326 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	ErrPatchPathNotValid          string = "patch path '%s' is not valid"
+	ErrPatchOperationNotSupported string = "patch operation '%s' is not supported"
+	ErrMultipleFieldNotList       string = "field '%s' value should be a list"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// Patch changes entity document. Document keys are field names; multiple fields hold lists.
+// Apply returns names of changed fields.
+type Patch interface {
+	Apply(doc map[string]interface{}) ([]string, error)
+}
+
+// MergePatch is RFC 7396 JSON Merge Patch; null removes field
+type MergePatch map[string]interface{}
+
+// JSONPatch is RFC 6902 JSON Patch supporting add, remove and replace operations
+type JSONPatch []JSONPatchOperation
+
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+func (p MergePatch) Apply(doc map[string]interface{}) ([]string, error) {
+	var fields []string
+	for name, value := range p {
+		fields = append(fields, name)
+		if value == nil {
+			delete(doc, name)
+			continue
+		}
+		doc[name] = mergePatch(doc[name], value)
+	}
+	return fields, nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	var merged = map[string]interface{}{}
+	if t, ok := target.(map[string]interface{}); ok {
+		for k, v := range t {
+			merged[k] = v
+		}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = mergePatch(merged[k], v)
+		}
+	}
+	return merged
+}
+
+func (p JSONPatch) Apply(doc map[string]interface{}) ([]string, error) {
+	var fields []string
+	for _, op := range p {
+		if op.Op != "add" && op.Op != "remove" && op.Op != "replace" {
+			return fields, &FieldError{Field: op.Path, Code: CodeInvalid, Message: fmt.Sprintf(ErrPatchOperationNotSupported, op.Op)}
+		}
+
+		tokens, ok := parsePointer(op.Path)
+		if !ok {
+			return fields, &FieldError{Field: op.Path, Code: CodeInvalid, Message: fmt.Sprintf(ErrPatchPathNotValid, op.Path)}
+		}
+		name := tokens[0]
+		fields = append(fields, name)
+
+		current, exists := doc[name]
+		if len(tokens) == 1 {
+			if op.Op != "add" && !exists {
+				return fields, &FieldError{Field: name, Code: CodeInvalid, Message: fmt.Sprintf(ErrPatchPathNotValid, op.Path)}
+			}
+			if op.Op == "remove" {
+				delete(doc, name)
+			} else {
+				doc[name] = op.Value
+			}
+			continue
+		}
+
+		value, ok := patchValue(current, tokens[1:], op.Op, op.Value)
+		if !exists || !ok {
+			return fields, &FieldError{Field: name, Code: CodeInvalid, Message: fmt.Sprintf(ErrPatchPathNotValid, op.Path)}
+		}
+		doc[name] = value
+	}
+	return fields, nil
+}
+
+// parsePointer splits RFC 6901 JSON Pointer
+func parsePointer(path string) ([]string, bool) {
+	if !strings.HasPrefix(path, "/") || len(path) == 1 {
+		return nil, false
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, true
+}
+
+// patchValue applies operation at path inside list or object value and returns changed copy
+func patchValue(value interface{}, tokens []string, op string, v interface{}) (interface{}, bool) {
+	var token = tokens[0]
+	var last = len(tokens) == 1
+
+	switch c := value.(type) {
+	case []interface{}:
+		var list = append([]interface{}{}, c...)
+		// "-" is position after the last item
+		if token == "-" {
+			if last && op == "add" {
+				return append(list, v), true
+			}
+			return nil, false
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(list) || (i == len(list) && !(last && op == "add")) {
+			return nil, false
+		}
+		if !last {
+			child, ok := patchValue(list[i], tokens[1:], op, v)
+			list[i] = child
+			return list, ok
+		}
+		switch op {
+		case "add":
+			list = append(list[:i], append([]interface{}{v}, list[i:]...)...)
+		case "replace":
+			list[i] = v
+		case "remove":
+			list = append(list[:i], list[i+1:]...)
+		}
+		return list, true
+	case map[string]interface{}:
+		var m = map[string]interface{}{}
+		for k, val := range c {
+			m[k] = val
+		}
+		child, exists := m[token]
+		if !last {
+			if !exists {
+				return nil, false
+			}
+			child, ok := patchValue(child, tokens[1:], op, v)
+			m[token] = child
+			return m, ok
+		}
+		if op != "add" && !exists {
+			return nil, false
+		}
+		if op == "remove" {
+			delete(m, token)
+		} else {
+			m[token] = v
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// document returns entity values by field name in the form accepted by FromMap; hidden fields are left out
+func (e *EntityDataHolder) document() map[string]interface{} {
+	var doc = map[string]interface{}{}
+	for field, value := range e.data {
+		if field.Json == NoJsonOutput {
+			continue
+		}
+		if field.Multiple {
+			var values = []interface{}{}
+			for _, v := range value.([]interface{}) {
+				values = append(values, outputValue(v))
+			}
+			doc[field.datastoreFieldName] = values
+		} else {
+			doc[field.datastoreFieldName] = outputValue(value)
+		}
+	}
+	return doc
+}
+
+// Patch applies patch to stored entity within the Edit transaction and hooks. Only patched fields are
+// validated again; missing or null value clears the field.
+func (e *Entity) Patch(ctx Context, key *datastore.Key, patch Patch) (*EntityDataHolder, error) {
+	return e.patch(ctx, key, patch, RevisionEdit)
+}
+
+// patch applies patch; action is stored with revision
+func (e *Entity) patch(ctx Context, key *datastore.Key, patch Patch, action string) (*EntityDataHolder, error) {
+	// holder starts empty so that stored values are kept; prepared values would replace them
+	h, _, err := e.edit(ctx, key, action, func() *EntityDataHolder {
+		return &EntityDataHolder{Entity: e, data: Data{}, input: map[string]interface{}{}}
+	}, func(h *EntityDataHolder) error {
+		h.data[e.fields["_updatedAt"]] = time.Now()
+		if by := e.fields["_updatedBy"].ContextFunc(ctx); by != nil {
+			h.data[e.fields["_updatedBy"]] = by
+		} else {
+			delete(h.data, e.fields["_updatedBy"])
+		}
+
+		doc := h.document()
+		fields, err := patch.Apply(doc)
+		var verr = &ValidationError{}
+		if err != nil {
+			verr.add("", err)
+			return verr
+		}
+
+		for _, name := range fields {
+			field, ok := e.fields[name]
+			if !ok {
+				verr.Add(&FieldError{Field: name, Code: CodeInvalid, Message: fmt.Sprintf(ErrNamedFieldNotDefined, name)})
+				continue
+			}
+			if field.NoEdits {
+				verr.Add(newFieldError(field, CodeEditDenied, ErrFieldEditPermissionDenied))
+				continue
+			}
+
+			delete(h.data, field)
+
+			value, ok := doc[name]
+			if !ok || value == nil {
+				if field.IsRequired {
+					verr.Add(newFieldError(field, CodeRequired, ErrFieldRequired))
+				}
+				continue
+			}
+
+			var c = &ValueContext{Field: field, Trust: Base}
+			if !field.Multiple {
+				if err := h.appendFieldValue(field, value, c); err != nil {
+					verr.add(name, err)
+				}
+				continue
+			}
+
+			values, ok := value.([]interface{})
+			if !ok {
+				verr.Add(newFieldError(field, CodeType, ErrMultipleFieldNotList))
+				continue
+			}
+			h.data[field] = []interface{}{}
+			for _, v := range values {
+				if err := h.appendFieldValue(field, v, c); err != nil {
+					verr.add(name, err)
+				}
+			}
+		}
+
+		return verr.errOrNil()
+	})
+	return h, err
+}
+
+func (e *Entity) handlePatch() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r).WithBody().WithIfMatch(r.Header.Get("If-Match"))
+		vars := mux.Vars(r)
+
+		ctx, key, err := e.DecodeKey(ctx, vars["encodedKey"])
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var patch Patch
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case jsonPatchType:
+			var p JSONPatch
+			err = json.Unmarshal(ctx.body.body, &p)
+			patch = p
+		case mergePatchType, "application/json", "":
+			var p MergePatch
+			err = json.Unmarshal(ctx.body.body, &p)
+			patch = p
+		default:
+			ctx.PrintError(w, fmt.Errorf("content type '%s' is not supported", mediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		h, err := e.Patch(ctx, key, patch)
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, errorStatus(err))
+			return
+		}
+
+		w.Header().Set("ETag", h.ETag())
+		ctx.Print(w, h.Output(ctx))
+	}
+}
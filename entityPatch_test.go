@@ -0,0 +1,96 @@
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPatchKeepsCreator(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "patchPrivate", Private: true, Fields: []*Field{
+		{Name: "name", IsRequired: true},
+		{Name: "status", DefaultValue: "draft"},
+	}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := ctx
+	owner.User = datastore.NewKey(ctx.Context, userEntity.Name, "owner@example.com", 0, nil).Encode()
+	other := ctx
+	other.User = datastore.NewKey(ctx.Context, userEntity.Name, "other@example.com", 0, nil).Encode()
+
+	key := addTestItem(t, owner, e, map[string]interface{}{"name": "a", "status": "published"})
+	before, err := e.Get(owner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := before.data[e.fields["_createdAt"]].(time.Time)
+
+	if _, err = e.Patch(other, key, MergePatch{"name": "b"}); err != ErrNotAuthorized {
+		t.Fatalf("patch of other user returned %v, want ErrNotAuthorized", err)
+	}
+
+	h, err := e.Patch(owner, key, MergePatch{"name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !owner.UserMatches(h.data[e.fields["_createdBy"]]) {
+		t.Fatalf("_createdBy is %v after patch, want owner", h.data[e.fields["_createdBy"]])
+	}
+	if at, _ := h.data[e.fields["_createdAt"]].(time.Time); !at.Equal(createdAt) {
+		t.Fatalf("_createdAt is %v after patch, want %v", at, createdAt)
+	}
+	if status := h.Get(owner, "status"); status != "published" {
+		t.Fatalf("status is %v after patch, want published", status)
+	}
+	if name := h.Get(owner, "name"); name != "b" {
+		t.Fatalf("name is %v after patch, want b", name)
+	}
+	if !owner.UserMatches(h.data[e.fields["_updatedBy"]]) {
+		t.Fatalf("_updatedBy is %v after patch, want owner", h.data[e.fields["_updatedBy"]])
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTestEntity(t, "jsonPatchItem")
+	key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a", "n": 1, "tags": []interface{}{"x", "y"}})
+
+	h, err := e.Patch(ctx, key, JSONPatch{
+		{Op: "replace", Path: "/name", Value: "b"},
+		{Op: "add", Path: "/tags/-", Value: "z"},
+		{Op: "remove", Path: "/tags/0"},
+		{Op: "remove", Path: "/n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := h.Get(ctx, "name"); name != "b" {
+		t.Fatalf("name is %v, want b", name)
+	}
+	if n, ok := h.data[e.fields["n"]]; ok {
+		t.Fatalf("n is %v, want it removed", n)
+	}
+	tags, _ := h.data[e.fields["tags"]].([]interface{})
+	if len(tags) != 2 || tags[0] != "y" || tags[1] != "z" {
+		t.Fatalf("tags are %v, want [y z]", tags)
+	}
+
+	// failed patch changes nothing
+	_, err = e.Patch(ctx, key, JSONPatch{
+		{Op: "replace", Path: "/name", Value: "c"},
+		{Op: "move", Path: "/tags/0"},
+	})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("patch with unsupported operation returned %v, want ValidationError", err)
+	}
+	if _, err = e.Patch(ctx, key, JSONPatch{{Op: "replace", Path: "/tags/5", Value: "c"}}); err == nil {
+		t.Fatal("patch of missing list item is applied")
+	}
+	if h, _ = e.Get(ctx, key); h.Get(ctx, "name") != "b" {
+		t.Fatalf("name is %v after failed patches, want b", h.Get(ctx, "name"))
+	}
+}
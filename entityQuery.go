@@ -0,0 +1,181 @@
+package sdk
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrFilterOperatorNotSupported string = "filter operator '%s' is not supported"
+	ErrFilterFieldNotMultiple     string = "filter operator 'contains' requires field '%s' to be multiple"
+	ErrSortFieldNotDefined        string = "sort field '%s' is not defined"
+	ErrFilterInequalityFields     string = "inequality filters can be applied to one field only, not to '%s' and '%s'"
+)
+
+// filterOperators maps query string operators to store operators
+var filterOperators = map[string]string{
+	"eq":       "=",
+	"ne":       "!=",
+	"lt":       "<",
+	"le":       "<=",
+	"gt":       ">",
+	"ge":       ">=",
+	"in":       "in",
+	"contains": "=", // equality on a multiple field matches any of its values
+}
+
+// matches filter[field] and filter[0][field]; same for op and value
+var filterParamRgx = regexp.MustCompile(`^filter(?:\[(\d+)\])?\[(field|op|value)\]$`)
+
+// ParseQueryFilters reads filters from query parameters:
+//
+//	filter[field]=price&filter[op]=gt&filter[value]=10
+//	filter[0][field]=price&filter[0][op]=gt&filter[0][value]=10&filter[1][field]=color&filter[1][op]=in&filter[1][value]=red,blue
+//
+// Filters are applied together. Values are converted to field type. As with datastore, inequality filters can be
+// applied to one field only.
+func (e *Entity) ParseQueryFilters(q url.Values) ([]EntityQueryFilter, error) {
+	type rawFilter struct {
+		field  string
+		op     string
+		values []string
+	}
+
+	var raw = map[string]*rawFilter{}
+	var indexes []string
+	for name, values := range q {
+		m := filterParamRgx.FindStringSubmatch(name)
+		if m == nil || len(values) == 0 {
+			continue
+		}
+		f, ok := raw[m[1]]
+		if !ok {
+			f = &rawFilter{}
+			raw[m[1]] = f
+			indexes = append(indexes, m[1])
+		}
+		switch m[2] {
+		case "field":
+			f.field = values[0]
+		case "op":
+			f.op = values[0]
+		case "value":
+			f.values = append(f.values, values...)
+		}
+	}
+
+	// legacy filter without index goes first
+	sort.Slice(indexes, func(i, j int) bool {
+		a, err := strconv.Atoi(indexes[i])
+		if err != nil {
+			a = -1
+		}
+		b, err := strconv.Atoi(indexes[j])
+		if err != nil {
+			b = -1
+		}
+		return a < b
+	})
+
+	var filters []EntityQueryFilter
+	for _, i := range indexes {
+		f := raw[i]
+		if len(f.field) == 0 {
+			continue
+		}
+		filter, err := e.newQueryFilter(f.field, f.op, f.values)
+		if err != nil {
+			return filters, err
+		}
+		filters = append(filters, filter)
+	}
+
+	var inequality string
+	for _, filter := range filters {
+		switch filter.Operator {
+		case "<", "<=", ">", ">=", "!=":
+			if len(inequality) > 0 && inequality != filter.Name {
+				return filters, fmt.Errorf(ErrFilterInequalityFields, inequality, filter.Name)
+			}
+			inequality = filter.Name
+		}
+	}
+
+	return filters, nil
+}
+
+func (e *Entity) newQueryFilter(name string, op string, values []string) (EntityQueryFilter, error) {
+	var filter = EntityQueryFilter{Name: name}
+
+	field, ok := e.fields[name]
+	if !ok || field.Json == NoJsonOutput {
+		return filter, fmt.Errorf(ErrNamedFieldNotDefined, name)
+	}
+
+	filter.Operator, ok = filterOperators[op]
+	if !ok {
+		return filter, fmt.Errorf(ErrFilterOperatorNotSupported, op)
+	}
+	if op == "contains" && !field.Multiple {
+		return filter, fmt.Errorf(ErrFilterFieldNotMultiple, name)
+	}
+
+	if op == "in" {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		var list []interface{}
+		for _, value := range values {
+			v, err := field.coerce(value)
+			if err != nil {
+				return filter, err
+			}
+			list = append(list, v)
+		}
+		filter.Value = list
+		return filter, nil
+	}
+
+	var value string
+	if len(values) > 0 {
+		value = values[0]
+	}
+	v, err := field.coerce(value)
+	if err != nil {
+		return filter, err
+	}
+	filter.Value = v
+
+	return filter, nil
+}
+
+// ParseQuerySort checks comma separated sort fields; "-" prefix sorts in descending order
+func (e *Entity) ParseQuerySort(sort string) (string, error) {
+	var orders []string
+	for _, order := range strings.Split(sort, ",") {
+		order = strings.TrimSpace(order)
+		if len(order) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(order, "-")
+		if field, ok := e.fields[name]; !ok || field.Json == NoJsonOutput {
+			return sort, fmt.Errorf(ErrSortFieldNotDefined, name)
+		}
+		orders = append(orders, order)
+	}
+	return strings.Join(orders, ","), nil
+}
+
+// coerce converts query string value to field type and checks its constraints; transform and validate
+// functions of the field are meant for input and are not applied
+func (f *Field) coerce(value string) (interface{}, error) {
+	fun := typeFunc(f)
+	if fun == nil {
+		return value, nil
+	}
+	return fun(&ValueContext{Trust: Base, Field: f}, value)
+}
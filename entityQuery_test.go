@@ -0,0 +1,48 @@
+package sdk
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseQueryFilters(t *testing.T) {
+	e := &Entity{Name: "queryItem", Fields: []*Field{
+		{Name: "price", Type: IntType},
+		{Name: "stock", Type: IntType},
+		{Name: "name", TransformFunc: func(c *ValueContext, v interface{}) (interface{}, error) {
+			return strings.ToUpper(fmt.Sprint(v)), nil
+		}},
+	}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	filters, err := e.ParseQueryFilters(url.Values{
+		"filter[0][field]": {"price"}, "filter[0][op]": {"ge"}, "filter[0][value]": {"10"},
+		"filter[1][field]": {"price"}, "filter[1][op]": {"lt"}, "filter[1][value]": {"20"},
+		"filter[2][field]": {"name"}, "filter[2][op]": {"eq"}, "filter[2][value]": {"shoe"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 3 || filters[0].Value != int64(10) || filters[1].Value != int64(20) {
+		t.Fatalf("filters are %v, want price values converted to int", filters)
+	}
+	if filters[2].Value != "shoe" {
+		t.Fatalf("name filter value is %v, want it not transformed", filters[2].Value)
+	}
+
+	if _, err = e.ParseQueryFilters(url.Values{
+		"filter[0][field]": {"price"}, "filter[0][op]": {"gt"}, "filter[0][value]": {"10"},
+		"filter[1][field]": {"stock"}, "filter[1][op]": {"ne"}, "filter[1][value]": {"0"},
+	}); err == nil {
+		t.Fatal("inequality filters on two fields are accepted")
+	}
+	if _, err = e.ParseQueryFilters(url.Values{
+		"filter[field]": {"price"}, "filter[op]": {"eq"}, "filter[value]": {"ten"},
+	}); err == nil {
+		t.Fatal("filter value of wrong type is accepted")
+	}
+}
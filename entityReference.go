@@ -0,0 +1,345 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// OnDelete policies of relation fields
+const (
+	OnDeleteRestrict = "restrict" // referenced entity can't be deleted
+	OnDeleteCascade  = "cascade"  // entities holding the reference are deleted too
+	OnDeleteSetNull  = "set_null" // reference is removed from the field
+)
+
+const (
+	ErrFieldOnDeleteNotValid string = "field '%s' onDelete policy is not valid"
+	ErrFieldSetNullRequired  string = "required field '%s' can't have set_null onDelete policy"
+	ErrEntityReferenced      string = "entity is referenced by %s field '%s'"
+)
+
+// ErrTooManyReferences is returned when changes of OnDelete policies don't fit in the transaction of the delete
+var ErrTooManyReferences = errors.New("entity is referenced by too many entities to delete it")
+
+// ReferenceError is returned when entity can't be deleted because of OnDeleteRestrict policy
+type ReferenceError struct {
+	Entity string // referencing entity
+	Field  string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf(ErrEntityReferenced, e.Entity, e.Field)
+}
+
+func validOnDelete(policy string) bool {
+	switch policy {
+	case "", OnDeleteRestrict, OnDeleteCascade, OnDeleteSetNull:
+		return true
+	}
+	return false
+}
+
+// referrer is a field of registered entity that references entity with OnDelete policy
+type referrer struct {
+	entity *Entity
+	field  *Field
+}
+
+func (e *Entity) referrers() []referrer {
+	var rs []referrer
+	for _, r := range Entities {
+		for _, field := range r.fields {
+			if field.Entity == e.Name && len(field.OnDelete) != 0 && !field.isSpecialField {
+				rs = append(rs, referrer{entity: r, field: field})
+			}
+		}
+	}
+	return rs
+}
+
+// keys returns keys of entities referencing key; zero limit means no limit
+func (r referrer) keys(ctx Context, key *datastore.Key, limit int) ([]*datastore.Key, error) {
+	// typed fields store keys, others encoded keys
+	var value interface{} = key
+	if r.field.Type != KeyType {
+		value = key.Encode()
+	}
+
+	var q = &StoreQuery{
+		Kind:    r.entity.Name,
+		Filters: []EntityQueryFilter{{Name: r.field.datastoreFieldName, Operator: "=", Value: value}},
+		Limit:   limit,
+	}
+	// trash is filtered by the store so that the limit counts live entities only
+	if r.entity.SoftDelete {
+		q.Filters = append(q.Filters, EntityQueryFilter{Name: deletedAtFieldName, Operator: "=", Value: nil})
+	}
+
+	var keys []*datastore.Key
+	t := store.Run(ctx.Context, q)
+	for {
+		k, err := t.Next(&EntityDataHolder{Entity: r.entity, data: Data{}})
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// onDeleteChange is an entity deleted or edited by OnDelete policies of entities it references
+type onDeleteChange struct {
+	entity *Entity
+	key    *datastore.Key
+	delete bool                        // cascade; otherwise references are removed
+	refs   map[*Field][]*datastore.Key // set_null fields and deleted keys they reference
+	h      *EntityDataHolder           // edited entity
+}
+
+// onDeletePlan holds changes of OnDelete policies of a deleted entity. Referrers are found, authorized and
+// checked before the delete; the changes are then written in the same transaction as the delete.
+type onDeletePlan struct {
+	changes []*onDeleteChange
+	groups  int // estimated entity groups written together with the delete
+}
+
+// writeGroups estimates entity groups a write of entity touches: the entity, its change event and markers of
+// old and new unique values
+func (e *Entity) writeGroups() int {
+	var n = 1 + 2*len(e.uniqueFields)
+	if e.hasSubscribers() {
+		n++
+	}
+	return n
+}
+
+// planOnDelete returns changes of deleting entity with key. It returns *ReferenceError if entity is referenced
+// by a field with OnDeleteRestrict policy, ErrNotAuthorized if the caller can't change a referencing entity and
+// ErrTooManyReferences if the changes don't fit in one transaction. Before delete hooks of cascaded entities
+// are called.
+func (e *Entity) planOnDelete(ctx Context, key *datastore.Key) (*onDeletePlan, error) {
+	var p = &onDeletePlan{groups: e.writeGroups()}
+	var deleted = map[string]bool{key.Encode(): true}
+	var edits = map[string]*onDeleteChange{}
+	if err := p.add(ctx, e, key, deleted, edits); err != nil {
+		return p, err
+	}
+
+	// entities deleted by a cascade are not edited
+	for encoded, ch := range edits {
+		if deleted[encoded] {
+			continue
+		}
+		stored, err := ch.entity.stored(ctx.Context, ch.key)
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			return p, err
+		}
+		if ch.entity.Private && !ctx.UserMatches(stored.Get(ctx, "_createdBy")) {
+			return p, ErrNotAuthorized
+		}
+		ch.removeReferences(stored)
+		if err = stored.Validate(); err != nil {
+			return p, err
+		}
+		p.changes = append(p.changes, ch)
+		p.groups += ch.entity.writeGroups()
+	}
+	if p.groups > maxTransactionGroups {
+		return p, ErrTooManyReferences
+	}
+	return p, nil
+}
+
+func (p *onDeletePlan) add(ctx Context, e *Entity, key *datastore.Key, deleted map[string]bool, edits map[string]*onDeleteChange) error {
+	for _, r := range e.referrers() {
+		var limit int
+		var scope = ScopeDelete
+		switch r.field.OnDelete {
+		case OnDeleteRestrict:
+			limit = 1
+		case OnDeleteSetNull:
+			scope = ScopeEdit
+		}
+		keys, err := r.keys(ctx, key, limit)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if r.field.OnDelete == OnDeleteRestrict {
+			return &ReferenceError{Entity: r.entity.Name, Field: r.field.datastoreFieldName}
+		}
+		if !ctx.HasScope(r.entity, scope) {
+			return ErrNotAuthorized
+		}
+
+		for _, k := range keys {
+			encoded := k.Encode()
+			if r.field.OnDelete == OnDeleteSetNull {
+				ch, ok := edits[encoded]
+				if !ok {
+					ch = &onDeleteChange{entity: r.entity, key: k, refs: map[*Field][]*datastore.Key{}}
+					edits[encoded] = ch
+				}
+				ch.refs[r.field] = append(ch.refs[r.field], key)
+				continue
+			}
+
+			if deleted[encoded] {
+				continue
+			}
+			deleted[encoded] = true
+			if err := r.entity.keyHook(HookBeforeDelete, ctx, k); err != nil {
+				return err
+			}
+			p.changes = append(p.changes, &onDeleteChange{entity: r.entity, key: k, delete: true})
+			p.groups += r.entity.writeGroups()
+			if err := p.add(ctx, r.entity, k, deleted, edits); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeReferences removes deleted keys from set_null fields of h
+func (ch *onDeleteChange) removeReferences(h *EntityDataHolder) {
+	for field, keys := range ch.refs {
+		if !field.Multiple {
+			delete(h.data, field)
+			continue
+		}
+		var removed = map[string]bool{}
+		for _, key := range keys {
+			removed[key.Encode()] = true
+		}
+		var values = []interface{}{}
+		for _, v := range fieldValues(field, h.data[field]) {
+			if !removed[encodedKey(v)] {
+				values = append(values, v)
+			}
+		}
+		h.data[field] = values
+	}
+}
+
+// apply writes change in transaction c
+func (ch *onDeleteChange) apply(c context.Context, ctx Context) error {
+	var err error
+	if ch.delete {
+		if ch.entity.SoftDelete {
+			err = ch.entity.trash(c, ctx, ch.key)
+		} else {
+			err = ch.entity.purge(c, ctx, ch.key)
+		}
+		// entity might be deleted since the plan was made
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		return err
+	}
+
+	e := ch.entity
+	old, err := e.stored(c, ch.key)
+	if err == datastore.ErrNoSuchEntity || (err == nil && old.isDeleted()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if e.Private && !ctx.UserMatches(old.Get(ctx, "_createdBy")) {
+		return ErrNotAuthorized
+	}
+	h, err := e.stored(c, ch.key)
+	if err != nil {
+		return err
+	}
+	h.isNew = false
+	ch.removeReferences(h)
+	h.setVersion(old.Version() + 1)
+	if err = e.beforeWrite(ctx, HookBeforeEdit, h); err != nil {
+		return err
+	}
+	if _, err = store.Put(c, ch.key, h); err != nil {
+		return err
+	}
+	if err = e.recordChange(c, ctx, ch.key, h.Version(), RevisionEdit, old.document(), h.document()); err != nil {
+		return err
+	}
+	if err = e.claimUnique(c, ch.key, h, old); err != nil {
+		return err
+	}
+	h.Id = ch.key.Encode()
+	ch.h = h
+	return nil
+}
+
+// apply writes changes in transaction c; entities in written are left out as the transaction writes them
+// already
+func (p *onDeletePlan) apply(c context.Context, ctx Context, written map[string]bool) error {
+	// If-Match applies to the deleted entity only
+	ctx = ctx.WithIfMatch("")
+	for _, ch := range p.changes {
+		encoded := ch.key.Encode()
+		if written[encoded] {
+			continue
+		}
+		written[encoded] = true
+		if err := ch.apply(c, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run deletes entity with del and applies changes in one transaction
+func (p *onDeletePlan) run(ctx Context, key *datastore.Key, del func(c context.Context) error) error {
+	err := store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+		if err := del(tc); err != nil {
+			return err
+		}
+		return p.apply(tc, ctx, map[string]bool{key.Encode(): true})
+	})
+	if err != nil {
+		return err
+	}
+	return p.after(ctx)
+}
+
+// after calls after hooks of changed entities and dispatches their change events
+func (p *onDeletePlan) after(ctx Context) error {
+	var dispatched = map[*Entity]bool{}
+	for _, ch := range p.changes {
+		if !dispatched[ch.entity] {
+			dispatched[ch.entity] = true
+			ch.entity.dispatchLater(ctx)
+		}
+		if !ch.delete {
+			if ch.h != nil {
+				if err := ch.entity.hook(HookAfterWrite, ctx, ch.h); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !ch.entity.SoftDelete && ch.entity.hasRedirects() {
+			if err := ch.entity.releaseRedirects(ctx.Context, ch.key); err != nil {
+				return err
+			}
+		}
+		if err := ch.entity.keyHook(HookAfterDelete, ctx, ch.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
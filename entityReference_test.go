@@ -0,0 +1,82 @@
+package sdk
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestOnDeleteRestrictIgnoresTrash(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	parent := newTestEntity(t, "restrictParent")
+	child := &Entity{Name: "restrictChild", SoftDelete: true, Fields: []*Field{
+		{Name: "parent", Type: KeyType, Entity: parent.Name, OnDelete: OnDeleteRestrict},
+	}}
+	if _, err := child.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	parentKey := addTestItem(t, ctx, parent, map[string]interface{}{"name": "a"})
+	trashed := addTestItem(t, ctx, child, map[string]interface{}{"parent": parentKey.Encode()})
+	if err := child.Delete(ctx, trashed); err != nil {
+		t.Fatal(err)
+	}
+	live := addTestItem(t, ctx, child, map[string]interface{}{"parent": parentKey.Encode()})
+
+	if _, ok := parent.Delete(ctx, parentKey).(*ReferenceError); !ok {
+		t.Fatal("delete referenced by live entity isn't restricted")
+	}
+
+	if err := child.Delete(ctx, live); err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Delete(ctx, parentKey); err != nil {
+		t.Fatalf("delete referenced by trash only returned %v", err)
+	}
+}
+
+func TestOnDeleteCascadeTooLarge(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	parent := newTestEntity(t, "cascadeParent")
+	child := &Entity{Name: "cascadeChild", Fields: []*Field{
+		{Name: "parent", Type: KeyType, Entity: parent.Name, OnDelete: OnDeleteCascade},
+	}}
+	if _, err := child.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	parentKey := addTestItem(t, ctx, parent, map[string]interface{}{"name": "a"})
+	var children []*datastore.Key
+	for i := 0; i < maxTransactionGroups; i++ {
+		children = append(children, addTestItem(t, ctx, child, map[string]interface{}{"parent": parentKey.Encode()}))
+	}
+
+	err := parent.Delete(ctx, parentKey)
+	if err != ErrTooManyReferences {
+		t.Fatalf("delete returned %v, want ErrTooManyReferences", err)
+	}
+	if status := errorStatus(err); status != http.StatusConflict {
+		t.Fatalf("status is %d, want 409", status)
+	}
+	if _, err = parent.Get(ctx, parentKey); err != nil {
+		t.Fatalf("parent is deleted: %v", err)
+	}
+	for _, k := range children {
+		if _, err = child.Get(ctx, k); err != nil {
+			t.Fatalf("child is deleted: %v", err)
+		}
+	}
+
+	if err = child.Delete(ctx, children[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = parent.Delete(ctx, parentKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range children {
+		if _, err = child.Get(ctx, k); err != datastore.ErrNoSuchEntity {
+			t.Fatalf("child isn't cascaded: %v", err)
+		}
+	}
+}
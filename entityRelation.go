@@ -0,0 +1,286 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	ErrExpandFieldNotRelation string = "field '%s' is not a relation"
+	ErrFieldReferenceNotFound string = "field '%s' references entity that doesn't exist"
+)
+
+// maxExpandDepth limits nesting of expanded relations
+const maxExpandDepth = 3
+
+var ErrExpandTooDeep = fmt.Errorf("relations can be expanded at most %d levels deep", maxExpandDepth)
+
+// expandTree holds relations to expand by field and nested relations of each
+type expandTree map[*Field]expandTree
+
+// parseExpand parses field paths such as author or tags.category
+func (e *Entity) parseExpand(paths []string) (expandTree, error) {
+	var tree = expandTree{}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if len(names) > maxExpandDepth {
+			return tree, ErrExpandTooDeep
+		}
+
+		var current = e
+		var node = tree
+		for _, name := range names {
+			field, ok := current.fields[name]
+			if !ok || len(field.Entity) == 0 || Entities[field.Entity] == nil {
+				return tree, fmt.Errorf(ErrExpandFieldNotRelation, name)
+			}
+			child, ok := node[field]
+			if !ok {
+				child = expandTree{}
+				node[field] = child
+			}
+			node = child
+			current = Entities[field.Entity]
+		}
+	}
+	return tree, nil
+}
+
+// Expand outputs entities with related entities embedded in place of their keys. Paths are comma separated
+// lists of relation fields; nested relations are separated with dots. Related entities are read with one
+// GetMulti call per field and level. Relations the caller has no read access to are left as keys and
+// missing entities are output as null. Lookup fields are not looked up unless listed.
+func (e *Entity) Expand(ctx Context, hs []*EntityDataHolder, paths ...string) ([]map[string]interface{}, error) {
+	tree, err := e.parseExpand(paths)
+	if err != nil {
+		return nil, err
+	}
+	return e.expand(ctx, hs, tree)
+}
+
+func (e *Entity) expand(ctx Context, hs []*EntityDataHolder, tree expandTree) ([]map[string]interface{}, error) {
+	var outs = make([]map[string]interface{}, len(hs))
+	for i, h := range hs {
+		outs[i] = output(ctx, h.Id, h.data, false)
+	}
+
+	for field, children := range tree {
+		var ids []string
+		var seen = map[string]bool{}
+		for _, h := range hs {
+			for _, v := range fieldValues(field, h.data[field]) {
+				if id := encodedKey(v); len(id) != 0 && !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+
+		target := Entities[field.Entity]
+		related, err := target.related(ctx, ids)
+		if err != nil {
+			return outs, err
+		}
+
+		var relatedHs []*EntityDataHolder
+		for _, h := range related {
+			if h != nil {
+				relatedHs = append(relatedHs, h)
+			}
+		}
+		nested, err := target.expand(ctx, relatedHs, children)
+		if err != nil {
+			return outs, err
+		}
+		var relatedOuts = map[string]map[string]interface{}{}
+		for i, out := range nested {
+			relatedOuts[relatedHs[i].Id] = out
+		}
+
+		var embed = func(v interface{}) interface{} {
+			id := encodedKey(v)
+			if out, ok := relatedOuts[id]; ok {
+				return out
+			}
+			if h, ok := related[id]; ok && h == nil {
+				return nil
+			}
+			return outputValue(v)
+		}
+		for i, h := range hs {
+			if value, ok := h.data[field]; ok {
+				setOutputValue(outs[i], field, value, embed)
+			}
+		}
+	}
+
+	return outs, nil
+}
+
+// related reads entities by encoded keys. Missing and deleted entities are nil; entities the caller can't read
+// are left out.
+func (e *Entity) related(ctx Context, ids []string) (map[string]*EntityDataHolder, error) {
+	var found = map[string]*EntityDataHolder{}
+	if len(ids) == 0 || !ctx.HasScope(e, ScopeRead) {
+		return found, nil
+	}
+
+	var keys []*datastore.Key
+	var hs []*EntityDataHolder
+	var dst []datastore.PropertyLoadSaver
+	for _, id := range ids {
+		key, err := datastore.DecodeKey(id)
+		if err != nil || key.Kind() != e.Name {
+			continue
+		}
+		var h = e.New(ctx)
+		h.isNew = false
+		h.Id = id
+		keys = append(keys, key)
+		hs = append(hs, h)
+		dst = append(dst, h)
+	}
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	err := store.GetMulti(ctx.Context, keys, dst)
+	multiErr, isMulti := err.(appengine.MultiError)
+	if err != nil && !isMulti {
+		return found, err
+	}
+
+	for i, h := range hs {
+		if isMulti && multiErr[i] != nil {
+			if multiErr[i] == datastore.ErrNoSuchEntity {
+				found[h.Id] = nil
+			}
+			continue
+		}
+		if h.isDeleted() {
+			found[h.Id] = nil
+			continue
+		}
+		if e.Private {
+			if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
+				continue
+			}
+		}
+		if err := e.hook(HookAfterRead, ctx, h); err != nil {
+			return found, err
+		}
+		found[h.Id] = h
+	}
+	return found, nil
+}
+
+// fieldValues returns values of single or multiple field as a list
+func fieldValues(field *Field, value interface{}) []interface{} {
+	if value == nil {
+		return nil
+	}
+	if field.Multiple {
+		values, _ := value.([]interface{})
+		return values
+	}
+	return []interface{}{value}
+}
+
+// setOutputValue replaces field value in output; multiple group values are set on group items
+func setOutputValue(out map[string]interface{}, field *Field, value interface{}, convert func(v interface{}) interface{}) {
+	if len(field.GroupName) == 0 {
+		if !field.Multiple {
+			out[field.Name] = convert(value)
+			return
+		}
+		var values = []interface{}{}
+		for _, v := range fieldValues(field, value) {
+			values = append(values, convert(v))
+		}
+		out[field.Name] = values
+		return
+	}
+
+	group, ok := out[field.GroupName].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if !field.Multiple {
+		group[field.Name] = convert(value)
+		return
+	}
+	items, _ := group["items"].([]map[string]interface{})
+	for i, v := range fieldValues(field, value) {
+		if i < len(items) {
+			items[i][field.Name] = convert(v)
+		}
+	}
+}
+
+// checkReferences checks that entities referenced by relation fields exist
+func (e *Entity) checkReferences(ctx Context, h *EntityDataHolder) error {
+	var verr = &ValidationError{}
+
+	var fields []*Field
+	var keys []*datastore.Key
+	var dst []datastore.PropertyLoadSaver
+	for field, value := range h.data {
+		if field.isSpecialField || len(field.Entity) == 0 {
+			continue
+		}
+		target, ok := Entities[field.Entity]
+		if !ok {
+			continue
+		}
+		for _, v := range fieldValues(field, value) {
+			key, ok := toKey(v)
+			if !ok || key.Incomplete() || key.Kind() != target.Name {
+				verr.Add(newFieldError(field, CodeReference, ErrFieldReferenceNotFound))
+				continue
+			}
+			fields = append(fields, field)
+			keys = append(keys, key)
+			dst = append(dst, &EntityDataHolder{Entity: target, data: Data{}})
+		}
+	}
+
+	if len(keys) > 0 {
+		err := store.GetMulti(ctx.Context, keys, dst)
+		multiErr, isMulti := err.(appengine.MultiError)
+		if err != nil && !isMulti {
+			return err
+		}
+		for i, field := range fields {
+			if isMulti && multiErr[i] != nil {
+				if multiErr[i] != datastore.ErrNoSuchEntity {
+					return multiErr[i]
+				}
+				verr.Add(newFieldError(field, CodeReference, ErrFieldReferenceNotFound))
+				continue
+			}
+			if dst[i].(*EntityDataHolder).isDeleted() {
+				verr.Add(newFieldError(field, CodeReference, ErrFieldReferenceNotFound))
+			}
+		}
+	}
+
+	return verr.errOrNil()
+}
+
+// outputs outputs entities expanding relations listed in expand
+func (e *Entity) outputs(ctx Context, hs []*EntityDataHolder, expand string) ([]map[string]interface{}, error) {
+	if len(expand) == 0 {
+		var data []map[string]interface{}
+		for _, h := range hs {
+			data = append(data, h.Output(ctx))
+		}
+		return data, nil
+	}
+	return e.Expand(ctx, hs, strings.Split(expand, ",")...)
+}
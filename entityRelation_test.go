@@ -0,0 +1,36 @@
+package sdk
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// unavailableStore fails reads of many entities
+type unavailableStore struct {
+	Store
+}
+
+var errUnavailable = errors.New("store is unavailable")
+
+func (s unavailableStore) GetMulti(c context.Context, keys []*datastore.Key, dst []datastore.PropertyLoadSaver) error {
+	return errUnavailable
+}
+
+func TestRelatedReturnsStoreError(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTestEntity(t, "relatedItem")
+	key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a"})
+
+	found, err := e.related(ctx, []string{key.Encode()})
+	if err != nil || found[key.Encode()] == nil {
+		t.Fatalf("related returned %v and %v, want the entity", found, err)
+	}
+
+	store = unavailableStore{store}
+	if _, err = e.related(ctx, []string{key.Encode()}); err != errUnavailable {
+		t.Fatalf("related returned %v, want store error", err)
+	}
+}
@@ -0,0 +1,223 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaPath is where entity JSON Schema is served
+func schemaPath(name string) string {
+	return apiPath + "entity/" + name + "/schema"
+}
+
+// JSONSchema describes entity output. Fields with NoJsonOutput are omitted, lookup fields reference
+// schema of the looked up entity.
+func (e *Entity) JSONSchema() map[string]interface{} {
+	schema := e.jsonSchema(schemaPath)
+	schema["$schema"] = jsonSchemaDialect
+	schema["$id"] = schemaPath(e.Name)
+	return schema
+}
+
+// jsonSchema builds entity schema; ref returns reference to schema of other entity
+func (e *Entity) jsonSchema(ref func(name string) string) map[string]interface{} {
+	var properties = map[string]interface{}{
+		"_id": map[string]interface{}{
+			"type":     "string",
+			"readOnly": true,
+		},
+	}
+	var required []string
+	var groups = map[string]*schemaGroup{}
+
+	for _, field := range e.sortedFields() {
+		if field.Json == NoJsonOutput {
+			continue
+		}
+
+		var schema = field.jsonSchema(ref)
+
+		if len(field.GroupName) != 0 {
+			group, ok := groups[field.GroupName]
+			if !ok {
+				group = &schemaGroup{properties: map[string]interface{}{}}
+				groups[field.GroupName] = group
+			}
+			// multiple group fields are output as items with single values
+			if field.Multiple {
+				group.multiple = true
+				schema = schema["items"].(map[string]interface{})
+			}
+			group.properties[field.Name] = schema
+			if field.IsRequired {
+				group.required = append(group.required, field.Name)
+			}
+			continue
+		}
+
+		properties[field.Name] = schema
+		if field.IsRequired {
+			required = append(required, field.Name)
+		}
+	}
+
+	for name, group := range groups {
+		properties[name] = group.schema()
+	}
+
+	var schema = map[string]interface{}{
+		"title":      e.Name,
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+type schemaGroup struct {
+	properties map[string]interface{}
+	required   []string
+	multiple   bool
+}
+
+func (g *schemaGroup) schema() map[string]interface{} {
+	var item = map[string]interface{}{
+		"type":       "object",
+		"properties": g.properties,
+	}
+	if len(g.required) > 0 {
+		item["required"] = g.required
+	}
+	if !g.multiple {
+		return item
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"count": map[string]interface{}{"type": "integer"},
+			"items": map[string]interface{}{"type": "array", "items": item},
+		},
+	}
+}
+
+func (f *Field) jsonSchema(ref func(name string) string) map[string]interface{} {
+	var schema = map[string]interface{}{}
+
+	switch f.Type {
+	case IntType:
+		schema["type"] = "integer"
+	case FloatType:
+		schema["type"] = "number"
+	case BoolType:
+		schema["type"] = "boolean"
+	case DateTimeType:
+		schema["type"] = "string"
+		schema["format"] = "date-time"
+	case TextType:
+		schema["type"] = "string"
+	case HTMLType:
+		schema["type"] = "string"
+		schema["contentMediaType"] = "text/html"
+	case GeoPointType:
+		schema["type"] = "object"
+		schema["properties"] = map[string]interface{}{
+			"lat": map[string]interface{}{"type": "number"},
+			"lng": map[string]interface{}{"type": "number"},
+		}
+	case KeyType:
+		schema["type"] = "string"
+	case SlugType:
+		schema["type"] = "string"
+		schema["pattern"] = "^[a-z0-9]+(-[a-z0-9]+)*$"
+	case EnumType:
+		schema["type"] = "string"
+		schema["enum"] = f.Enum
+	case FileType, ImageType:
+		schema["type"] = "string"
+		schema["format"] = "uri"
+	default:
+		switch f.DefaultValue.(type) {
+		case bool:
+			schema["type"] = "boolean"
+		case int64:
+			schema["type"] = "integer"
+		case float64:
+			schema["type"] = "number"
+		case string:
+			schema["type"] = "string"
+		case time.Time:
+			schema["type"] = "string"
+			schema["format"] = "date-time"
+		}
+	}
+
+	if len(f.Entity) > 0 {
+		if f.Lookup {
+			schema = map[string]interface{}{"$ref": ref(f.Entity)}
+		} else {
+			schema["type"] = "string"
+			schema["description"] = "encoded " + f.Entity + " key"
+		}
+	}
+
+	if f.Min != nil {
+		schema["minimum"] = *f.Min
+	}
+	if f.Max != nil {
+		schema["maximum"] = *f.Max
+	}
+	if f.MinLength > 0 {
+		schema["minLength"] = f.MinLength
+	}
+	if f.MaxLength > 0 {
+		schema["maxLength"] = f.MaxLength
+	}
+	if len(f.ValidateRgx) > 0 {
+		schema["pattern"] = f.ValidateRgx
+	}
+	if f.NoEdits {
+		schema["readOnly"] = true
+	}
+	if f.DefaultValue != nil {
+		schema["default"] = f.DefaultValue
+	}
+	if label, ok := f.Meta["label"].(string); ok {
+		schema["title"] = label
+	}
+
+	if f.Multiple {
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schema,
+		}
+	}
+	return schema
+}
+
+// sortedFields returns fields by datastore name so that generated documents are stable
+func (e *Entity) sortedFields() []*Field {
+	var names []string
+	for name := range e.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []*Field
+	for _, name := range names {
+		fields = append(fields, e.fields[name])
+	}
+	return fields
+}
+
+func (e *Entity) handleSchema() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		json.NewEncoder(w).Encode(e.JSONSchema())
+	}
+}
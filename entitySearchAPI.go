@@ -10,7 +10,8 @@ import (
 )
 
 func (a *SDK) EnableEntitySearchAPI(e *Entity, index *DocumentDefinition, fieldPosition []string) {
-	a.HandleFunc("/"+e.Name+"/search", e.handleSearch(index, fieldPosition)).Methods(http.MethodGet)
+	a.describe(a.HandleFunc("/"+e.Name+"/search", e.handleSearch(index, fieldPosition)).Methods(http.MethodGet),
+		&apiOperation{summary: "Search " + e.Name, tag: e.Name, query: []string{"q", "sort", "limit", "offset", "cursor", "fetch"}})
 }
 
 func (e *Entity) handleSearch(dd *DocumentDefinition, fieldPosition []string) func(w http.ResponseWriter, r *http.Request) {
@@ -20,7 +21,7 @@ func (e *Entity) handleSearch(dd *DocumentDefinition, fieldPosition []string) fu
 		q := r.URL.Query()
 		fetch := q.Get("fetch")
 
-		results, err := indexQuery(ctx.Context, dd, q)
+		results, nextCursor, err := indexQuery(ctx.Context, dd, q)
 		if err != nil {
 			ctx.PrintError(w, err, http.StatusInternalServerError)
 			return
@@ -29,6 +30,7 @@ func (e *Entity) handleSearch(dd *DocumentDefinition, fieldPosition []string) fu
 		if len(fetch) > 0 {
 			var keys []*datastore.Key
 			var multiData []*EntityDataHolder
+			var dst []datastore.PropertyLoadSaver
 			for _, item := range results {
 				if fetchEncodedIdField, ok := item[fetch]; ok {
 					key, err := datastore.DecodeKey(fetchEncodedIdField.(string))
@@ -37,14 +39,16 @@ func (e *Entity) handleSearch(dd *DocumentDefinition, fieldPosition []string) fu
 						return
 					}
 					keys = append(keys, key)
-					multiData = append(multiData, e.New(ctx))
+					h := e.New(ctx)
+					multiData = append(multiData, h)
+					dst = append(dst, h)
 				}
 			}
 
 			results = []map[string]interface{}{}
 
 			if len(keys) > 0 {
-				err := datastore.GetMulti(ctx.Context, keys, multiData)
+				err := store.GetMulti(ctx.Context, keys, dst)
 				if err != nil {
 					ctx.PrintError(w, err, http.StatusInternalServerError)
 					return
@@ -58,22 +62,26 @@ func (e *Entity) handleSearch(dd *DocumentDefinition, fieldPosition []string) fu
 		}
 
 		ctx.Print(w, map[string]interface{}{
-			"fields": fieldPosition,
-			"data":   results,
-			"count":  len(results),
+			"fields":     fieldPosition,
+			"data":       results,
+			"count":      len(results),
+			"nextCursor": nextCursor,
 		})
 	}
 }
 
-func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) ([]map[string]interface{}, error) {
+// indexQuery searches the index and returns a cursor for the next page if the page was full
+func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) ([]map[string]interface{}, string, error) {
 	var data []map[string]interface{}
+	var nextCursor string
 
 	index, err := search.Open(dd.Name)
 	if err != nil {
-		return data, err
+		return data, nextCursor, err
 	}
 
 	var searchString = query.Get("q")
+	var cursor = query.Get("cursor")
 	var sort = query.Get("sort")
 	var limit_str = query.Get("limit")
 	var offset_str = query.Get("offset")
@@ -92,15 +100,15 @@ func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) (
 	if len(limit_str) != 0 {
 		limit, err = strconv.Atoi(limit_str)
 		if err != nil {
-			return data, err
+			return data, nextCursor, err
 		}
 	}
 
-	var offset = 0 // default offset
-	if len(offset_str) != 0 {
+	var offset = 0 // default offset; search doesn't accept both cursor and offset
+	if len(offset_str) != 0 && len(cursor) == 0 {
 		offset, err = strconv.Atoi(offset_str)
 		if err != nil {
-			return data, err
+			return data, nextCursor, err
 		}
 	}
 
@@ -110,6 +118,7 @@ func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) (
 		},
 		Limit:  limit,
 		Offset: offset,
+		Cursor: search.Cursor(cursor),
 	})
 
 	for {
@@ -119,7 +128,7 @@ func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) (
 			break
 		}
 		if err != nil {
-			return data, err
+			return data, nextCursor, err
 		}
 		var docData = map[string]interface{}{}
 		for _, field := range doc.Fields {
@@ -138,5 +147,9 @@ func indexQuery(ctx context.Context, dd *DocumentDefinition, query url.Values) (
 		data = append(data, docData)
 	}
 
-	return data, nil
+	if len(data) == limit {
+		nextCursor = string(it.Cursor())
+	}
+
+	return data, nextCursor, nil
 }
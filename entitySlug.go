@@ -0,0 +1,227 @@
+package sdk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const ErrFieldSourceNotDefined string = "field '%s' source is not a field"
+
+var ErrEntityHasNoSlug = errors.New("entity has no slug field")
+
+// maxSlugLength limits length of generated slugs
+const maxSlugLength = 100
+
+// maxSlugSuffix is the highest number appended to a taken slug
+const maxSlugSuffix = 100
+
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a", 'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ł': "l", 'ľ': "l", 'ĺ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ŕ': "r", 'ř': "r", 'ś': "s", 'š': "s", 'ș': "s", 'ş': "s", 'ß': "ss",
+	'ť': "t", 'ț': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ů': "u", 'ū': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Slugify returns lowercase URL-safe form of s. Latin letters with diacritics are transliterated, other
+// characters are replaced with dashes.
+func Slugify(s string) string {
+	var b bytes.Buffer
+	var dash bool
+	for _, r := range strings.ToLower(s) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			dash = false
+			continue
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if b.Len() > 0 && !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+	}
+	return strings.TrimRight(slug, "-")
+}
+
+func toSlug(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	slug := Slugify(s)
+	return slug, len(slug) != 0 || len(s) == 0
+}
+
+// setSlugs generates slugs of new entities from their source fields. Fields with Redirects get a new slug when
+// the source value changes; previous slugs stay reserved and redirect to the entity. Slugs set in input are
+// kept. Taken slugs get a numbered suffix; reserved holds slugs of other batch items and can be nil.
+func (e *Entity) setSlugs(c context.Context, key *datastore.Key, h *EntityDataHolder, before *EntityDataHolder, reserved map[string]bool) error {
+	for _, field := range e.slugFields {
+		if len(field.Source) == 0 {
+			continue
+		}
+		source := e.fields[field.Source]
+
+		if current, _ := h.data[field].(string); len(current) != 0 {
+			if before == nil || !field.Redirects {
+				continue
+			}
+			// slug was changed in input or source didn't change
+			if old, _ := before.data[field].(string); current != old || csvValue(h.data[source]) == csvValue(before.data[source]) {
+				continue
+			}
+		}
+
+		base := Slugify(csvValue(h.data[source]))
+		if len(base) == 0 {
+			continue
+		}
+		slug, err := e.freeSlug(c, key, field, base, reserved)
+		if err != nil {
+			return err
+		}
+		h.data[field] = slug
+	}
+	return nil
+}
+
+// freeSlug returns base or base with the lowest numbered suffix that is not used by another entity
+func (e *Entity) freeSlug(c context.Context, key *datastore.Key, field *Field, base string, reserved map[string]bool) (string, error) {
+	for i := 1; i <= maxSlugSuffix; i++ {
+		slug := base
+		if i > 1 {
+			slug = base + "-" + strconv.Itoa(i)
+		}
+		markerKey := e.uniqueKey(c, field, slug)
+		if reserved[markerKey.StringID()] {
+			continue
+		}
+		record, found, err := e.uniqueOwner(c, markerKey)
+		if err != nil {
+			return "", err
+		}
+		if !found || (record != nil && !key.Incomplete() && record.Equal(key)) {
+			return slug, nil
+		}
+	}
+	return "", newUniqueError(field, base)
+}
+
+// releaseRedirects removes markers of previous slugs of removed entity
+func (e *Entity) releaseRedirects(c context.Context, key *datastore.Key) error {
+	var keys []*datastore.Key
+	t := store.Run(c, &StoreQuery{
+		Kind:    e.uniqueMarkers.Name,
+		Filters: []EntityQueryFilter{{Name: "record", Operator: "=", Value: key}},
+	})
+	for {
+		k, err := t.Next(&EntityDataHolder{Entity: e.uniqueMarkers, data: Data{}})
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return store.DeleteMulti(c, keys)
+}
+
+func (e *Entity) hasRedirects() bool {
+	for _, field := range e.slugFields {
+		if field.Redirects {
+			return true
+		}
+	}
+	return false
+}
+
+// BySlug returns entity by value of its first slug field. If slug is a previous slug of the entity, redirect is
+// true; the returned entity holds the current one.
+func (e *Entity) BySlug(ctx Context, slug string) (h *EntityDataHolder, redirect bool, err error) {
+	if len(e.slugFields) == 0 {
+		return nil, false, ErrEntityHasNoSlug
+	}
+	field := e.slugFields[0]
+
+	key, found, err := e.uniqueOwner(ctx.Context, e.uniqueKey(ctx.Context, field, slug))
+	if err != nil {
+		return nil, false, err
+	}
+	if !found || key == nil {
+		return nil, false, datastore.ErrNoSuchEntity
+	}
+
+	h, err = e.Get(ctx, key)
+	if err != nil {
+		return h, false, err
+	}
+	current, _ := h.data[field].(string)
+	return h, current != slug, nil
+}
+
+// handleBySlug outputs entity by slug; previous slugs are redirected to the current one so that paths such as
+// /:category/:slug keep working after the source changes
+func (e *Entity) handleBySlug() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+		slug := mux.Vars(r)["slug"]
+
+		h, redirect, err := e.BySlug(ctx, slug)
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
+		if err == ErrNotAuthorized {
+			ctx.PrintError(w, err, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if redirect {
+			location := path.Join(path.Dir(r.URL.Path), fmt.Sprint(h.data[e.slugFields[0]]))
+			if len(r.URL.RawQuery) != 0 {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+
+		data, err := e.outputs(ctx, []*EntityDataHolder{h}, r.FormValue("expand"))
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("ETag", h.ETag())
+		ctx.Print(w, data[0])
+	}
+}
@@ -0,0 +1,52 @@
+package sdk
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	for in, want := range map[string]string{
+		"Čevapčiči & Šopska Solata!": "cevapcici-sopska-solata",
+		"  --Hello   World-- ":       "hello-world",
+		"Straße 5":                   "strasse-5",
+		"日本":                         "",
+	} {
+		if got := Slugify(in); got != want {
+			t.Errorf("slug of %q is %q, want %q", in, got, want)
+		}
+	}
+	if _, ok := toSlug("日本"); ok {
+		t.Error("value without slug characters is accepted")
+	}
+}
+
+func TestSlugRedirects(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "slugItem", Fields: []*Field{
+		{Name: "title"},
+		{Name: "slug", Type: SlugType, Source: "title", Redirects: true},
+	}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := addTestItem(t, ctx, e, map[string]interface{}{"title": "Hello World"})
+	second := addTestItem(t, ctx, e, map[string]interface{}{"title": "Hello, world!"})
+	h, err := e.Get(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slug := h.Get(ctx, "slug"); slug != "hello-world-2" {
+		t.Fatalf("slug of second entity is %v, want hello-world-2", slug)
+	}
+
+	if _, err = e.Patch(ctx, first, MergePatch{"title": "Goodbye"}); err != nil {
+		t.Fatal(err)
+	}
+	h, redirect, err := e.BySlug(ctx, "goodbye")
+	if err != nil || redirect || h.Id != first.Encode() {
+		t.Fatalf("new slug returned %v and redirect %v, want the entity", err, redirect)
+	}
+	h, redirect, err = e.BySlug(ctx, "hello-world")
+	if err != nil || !redirect || h.Get(ctx, "slug") != "goodbye" {
+		t.Fatalf("previous slug returned %v and redirect %v, want redirect to goodbye", err, redirect)
+	}
+}
@@ -0,0 +1,291 @@
+package sdk
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// special fields of entities with SoftDelete enabled
+const (
+	deletedAtFieldName = "_deletedAt"
+	deletedByFieldName = "_deletedBy"
+)
+
+var ErrTrashSortNotValid = errors.New("trash sort has to start with " + deletedAtFieldName)
+
+// isDeleted returns true if entity is in trash
+func (e *EntityDataHolder) isDeleted() bool {
+	field, ok := e.Entity.fields[deletedAtFieldName]
+	if !ok {
+		return false
+	}
+	_, ok = e.data[field]
+	return ok
+}
+
+// trash marks entity deleted in transaction c. Search indexes are updated when changes are dispatched.
+func (e *Entity) trash(c context.Context, ctx Context, key *datastore.Key) error {
+	var h = e.New(ctx)
+	h.isNew = false
+	if err := store.Get(c, key, h); err != nil {
+		return err
+	}
+	if h.isDeleted() {
+		return datastore.ErrNoSuchEntity
+	}
+	if e.Private {
+		if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
+			return ErrNotAuthorized
+		}
+	}
+	if !h.matches(ctx.ifMatch) {
+		return ErrPreconditionFailed
+	}
+	before := h.document()
+
+	h.data[e.fields[deletedAtFieldName]] = time.Now()
+	if userKey, err := datastore.DecodeKey(ctx.User); err == nil {
+		h.data[e.fields[deletedByFieldName]] = userKey
+	}
+	h.setVersion(h.Version() + 1)
+
+	if _, err := store.Put(c, key, h); err != nil {
+		return err
+	}
+	return e.recordChange(c, ctx, key, h.Version(), RevisionDelete, before, h.document())
+}
+
+// Restore moves entity out of trash and adds it back to search indexes
+func (e *Entity) Restore(ctx Context, key *datastore.Key) (*EntityDataHolder, error) {
+	var h *EntityDataHolder
+	if !ctx.HasScope(e, ScopeDelete) {
+		return h, ErrNotAuthorized
+	}
+
+	err := store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+		h = e.New(ctx)
+		h.isNew = false
+		if err := store.Get(tc, key, h); err != nil {
+			return err
+		}
+		if !h.isDeleted() {
+			return datastore.ErrNoSuchEntity
+		}
+		if e.Private {
+			if !ctx.UserMatches(h.Get(ctx, "_createdBy")) {
+				return ErrNotAuthorized
+			}
+		}
+		before := h.document()
+
+		delete(h.data, e.fields[deletedAtFieldName])
+		delete(h.data, e.fields[deletedByFieldName])
+		h.setVersion(h.Version() + 1)
+
+		if _, err := store.Put(tc, key, h); err != nil {
+			return err
+		}
+		return e.recordChange(tc, ctx, key, h.Version(), RevisionRestore, before, h.document())
+	})
+	if err != nil {
+		return h, err
+	}
+
+	h.Id = key.Encode()
+	e.dispatchLater(ctx)
+	return h, nil
+}
+
+// MigrateSoftDelete stores empty deletion time on entities written before SoftDelete was enabled. Queries
+// filter trash by deletion time in the store and leave out entities without it, so run it once after enabling
+// SoftDelete on an entity with stored data, for example from a task. It returns number of updated entities.
+func (e *Entity) MigrateSoftDelete(c context.Context) (int, error) {
+	if !e.SoftDelete {
+		return 0, nil
+	}
+
+	var keys []*datastore.Key
+	t := store.Run(c, &StoreQuery{Kind: e.Name})
+	for {
+		var ps datastore.PropertyList
+		key, err := t.Next(&ps)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !hasProperty(ps, deletedAtFieldName) {
+			keys = append(keys, key)
+		}
+	}
+
+	var n int
+	for len(keys) != 0 {
+		chunk := keys
+		if len(chunk) > maxTransactionGroups {
+			chunk = chunk[:maxTransactionGroups]
+		}
+		keys = keys[len(chunk):]
+
+		var updated int
+		err := store.RunInTransaction(c, func(tc context.Context) error {
+			updated = 0
+			for _, key := range chunk {
+				var ps datastore.PropertyList
+				err := store.Get(tc, key, &ps)
+				if err == datastore.ErrNoSuchEntity {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if hasProperty(ps, deletedAtFieldName) {
+					continue
+				}
+				ps = append(ps, datastore.Property{Name: deletedAtFieldName})
+				if _, err = store.Put(tc, key, &ps); err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += updated
+	}
+	return n, nil
+}
+
+func hasProperty(ps datastore.PropertyList, name string) bool {
+	for _, p := range ps {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Trash returns deleted entities; last deleted first if sort is empty. Trash is filtered by deletion time so
+// sort has to start with it.
+func (e *Entity) Trash(ctx Context, sort string, limit int, offset int, cursor string) ([]*EntityDataHolder, string, error) {
+	if !e.SoftDelete {
+		return nil, "", nil
+	}
+	if !ctx.HasScope(e, ScopeDelete) {
+		return nil, "", ErrNotAuthorized
+	}
+	if len(sort) == 0 {
+		sort = "-" + deletedAtFieldName
+	}
+	if first := strings.TrimSpace(strings.Split(sort, ",")[0]); strings.TrimPrefix(first, "-") != deletedAtFieldName {
+		return nil, "", ErrTrashSortNotValid
+	}
+	return e.query(ctx, sort, limit, offset, cursor, true, EntityQueryFilter{
+		Name:     deletedAtFieldName,
+		Operator: ">",
+		Value:    time.Time{},
+	})
+}
+
+func (e *Entity) handleTrash() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+
+		q := r.URL.Query()
+
+		sort, err := e.ParseQuerySort(q.Get("sort"))
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		cursor := q.Get("cursor")
+		limitStr := q.Get("limit")
+		offsetStr := q.Get("offset")
+		var limit = 0
+		var offset = 0
+		if len(limitStr) != 0 {
+			limit, _ = strconv.Atoi(limitStr)
+		}
+		if len(offsetStr) != 0 {
+			offset, _ = strconv.Atoi(offsetStr)
+		}
+
+		dataHolder, nextCursor, err := e.Trash(ctx, sort, limit, offset, cursor)
+		if err == ErrInvalidCursor || err == ErrTrashSortNotValid {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		var data []map[string]interface{}
+		for _, h := range dataHolder {
+			data = append(data, h.Output(ctx))
+		}
+
+		ctx.Print(w, map[string]interface{}{
+			"data":       data,
+			"count":      len(data),
+			"nextCursor": nextCursor,
+		})
+	}
+}
+
+func (e *Entity) handleRestore() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+		vars := mux.Vars(r)
+
+		ctx, key, err := e.DecodeKey(ctx, vars["encodedKey"])
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		h, err := e.Restore(ctx, key)
+		if err == datastore.ErrNoSuchEntity {
+			ctx.PrintError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", h.ETag())
+		ctx.Print(w, h.Output(ctx))
+	}
+}
+
+func (e *Entity) handlePurge() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r).WithIfMatch(r.Header.Get("If-Match"))
+		vars := mux.Vars(r)
+
+		ctx, key, err := e.DecodeKey(ctx, vars["encodedKey"])
+		if err != nil {
+			ctx.PrintError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		err = e.Purge(ctx, key)
+		if err != nil {
+			ctx.PrintError(w, err, errorStatus(err))
+			return
+		}
+
+		ctx.Print(w, "success")
+	}
+}
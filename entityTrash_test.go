@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func newTrashTestEntity(t *testing.T, name string) *Entity {
+	e := &Entity{Name: name, SoftDelete: true, Fields: []*Field{{Name: "name", IsRequired: true}}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestTrash(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTrashTestEntity(t, "trashItem")
+	key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a"})
+	addTestItem(t, ctx, e, map[string]interface{}{"name": "b"})
+
+	if err := e.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Get(ctx, key); err != datastore.ErrNoSuchEntity {
+		t.Fatalf("get of trashed entity returned %v, want ErrNoSuchEntity", err)
+	}
+	if hs, _, err := e.Query(ctx, "", 0, 0, ""); err != nil || len(hs) != 1 {
+		t.Fatalf("query returned %d entities and %v, want 1", len(hs), err)
+	}
+	hs, _, err := e.Trash(ctx, "", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 1 || hs[0].Id != key.Encode() {
+		t.Fatalf("trash returned %d entities, want the deleted one", len(hs))
+	}
+	if _, _, err = e.Trash(ctx, "name", 0, 0, ""); err != ErrTrashSortNotValid {
+		t.Fatalf("trash sorted by name returned %v, want ErrTrashSortNotValid", err)
+	}
+
+	if _, err = e.Restore(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Get(ctx, key); err != nil {
+		t.Fatalf("get of restored entity returned %v", err)
+	}
+	if hs, _, _ = e.Trash(ctx, "", 0, 0, ""); len(hs) != 0 {
+		t.Fatalf("trash returned %d entities after restore, want 0", len(hs))
+	}
+
+	if err = e.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Purge(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Restore(ctx, key); err != datastore.ErrNoSuchEntity {
+		t.Fatalf("restore of purged entity returned %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestMigrateSoftDelete(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTrashTestEntity(t, "trashLegacy")
+
+	// entity written before SoftDelete was enabled
+	legacy := datastore.NewKey(ctx.Context, e.Name, "legacy", 0, nil)
+	if _, err := store.Put(ctx.Context, legacy, &datastore.PropertyList{{Name: "name", Value: "old"}}); err != nil {
+		t.Fatal(err)
+	}
+	addTestItem(t, ctx, e, map[string]interface{}{"name": "new"})
+
+	if hs, _, _ := e.Query(ctx, "", 0, 0, ""); len(hs) != 1 {
+		t.Fatalf("query returned %d entities before migration, want 1", len(hs))
+	}
+	n, err := e.MigrateSoftDelete(ctx.Context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("migration updated %d entities, want 1", n)
+	}
+	if hs, _, _ := e.Query(ctx, "", 0, 0, ""); len(hs) != 2 {
+		t.Fatalf("query returned %d entities after migration, want 2", len(hs))
+	}
+	if n, _ = e.MigrateSoftDelete(ctx.Context); n != 0 {
+		t.Fatalf("second migration updated %d entities, want 0", n)
+	}
+}
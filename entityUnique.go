@@ -0,0 +1,147 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const ErrFieldValueNotUnique string = "field '%s' value is already used"
+
+// maxUniqueNameLength keeps marker key names short; longer values are hashed
+const maxUniqueNameLength = 500
+
+// UniqueError is returned when value of unique field is used by another entity
+type UniqueError struct {
+	FieldError
+}
+
+func newUniqueError(field *Field, value string) *UniqueError {
+	fe := newFieldError(field, CodeUnique, ErrFieldValueNotUnique)
+	fe.Params = map[string]interface{}{"value": value}
+	return &UniqueError{FieldError: *fe}
+}
+
+// initUnique defines kind of markers reserving unique values. Marker key name is made of field name and
+// value, so checking a value is a get by key and works within datastore transactions.
+func (e *Entity) initUnique() error {
+	e.uniqueMarkers = &Entity{
+		Name: "_" + e.Name + "Unique",
+		Fields: []*Field{
+			{Name: "record", Type: KeyType, Entity: e.Name},
+		},
+	}
+	_, err := e.uniqueMarkers.init()
+	return err
+}
+
+// uniqueValues returns values of unique field as strings
+func uniqueValues(field *Field, h *EntityDataHolder) map[string]bool {
+	var values = map[string]bool{}
+	if h == nil {
+		return values
+	}
+	for _, v := range fieldValues(field, h.data[field]) {
+		if s := csvValue(v); len(s) != 0 {
+			values[s] = true
+		}
+	}
+	return values
+}
+
+func (e *Entity) uniqueKey(c context.Context, field *Field, value string) *datastore.Key {
+	name := field.datastoreFieldName + ":" + value
+	if len(name) > maxUniqueNameLength {
+		sum := sha256.Sum256([]byte(value))
+		name = field.datastoreFieldName + ":" + hex.EncodeToString(sum[:])
+	}
+	return datastore.NewKey(c, e.uniqueMarkers.Name, name, 0, nil)
+}
+
+// uniqueOwner returns key of entity holding the value of marker; found is false if value is free
+func (e *Entity) uniqueOwner(c context.Context, markerKey *datastore.Key) (record *datastore.Key, found bool, err error) {
+	marker, err := e.uniqueMarkers.stored(c, markerKey)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	record, _ = marker.data[e.uniqueMarkers.fields["record"]].(*datastore.Key)
+	return record, true, nil
+}
+
+// checkUnique returns *UniqueError if a unique value of h is reserved by another entity; key is nil for
+// entities that are not saved yet
+func (e *Entity) checkUnique(c context.Context, key *datastore.Key, h *EntityDataHolder) error {
+	for _, field := range e.uniqueFields {
+		for value := range uniqueValues(field, h) {
+			record, found, err := e.uniqueOwner(c, e.uniqueKey(c, field, value))
+			if err != nil {
+				return err
+			}
+			if found && (record == nil || key == nil || !record.Equal(key)) {
+				return newUniqueError(field, value)
+			}
+		}
+	}
+	return nil
+}
+
+// claimUnique reserves unique values of h for key and releases values of before that are not used anymore;
+// previous slugs of fields with Redirects are kept until the entity is removed. It must run in a transaction
+// together with the entity write; h is nil for removed and before for new entities.
+func (e *Entity) claimUnique(c context.Context, key *datastore.Key, h *EntityDataHolder, before *EntityDataHolder) error {
+	if len(e.uniqueFields) == 0 {
+		return nil
+	}
+	if err := e.checkUnique(c, key, h); err != nil {
+		return err
+	}
+
+	for _, field := range e.uniqueFields {
+		values := uniqueValues(field, h)
+		for value := range values {
+			var marker = &EntityDataHolder{Entity: e.uniqueMarkers, data: Data{
+				e.uniqueMarkers.fields["record"]: key,
+			}}
+			if _, err := store.Put(c, e.uniqueKey(c, field, value), marker); err != nil {
+				return err
+			}
+		}
+		for value := range uniqueValues(field, before) {
+			if !values[value] && (h == nil || !field.Redirects) {
+				if err := store.Delete(c, e.uniqueKey(c, field, value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reserveUnique checks unique values of batch item against stored markers and values reserved by items before it
+func (e *Entity) reserveUnique(c context.Context, key *datastore.Key, h *EntityDataHolder, reserved map[string]bool) error {
+	if len(e.uniqueFields) == 0 {
+		return nil
+	}
+	var record *datastore.Key
+	if !key.Incomplete() {
+		record = key
+	}
+	if err := e.checkUnique(c, record, h); err != nil {
+		return err
+	}
+	for _, field := range e.uniqueFields {
+		for value := range uniqueValues(field, h) {
+			name := e.uniqueKey(c, field, value).StringID()
+			if reserved[name] {
+				return newUniqueError(field, value)
+			}
+			reserved[name] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package sdk
+
+import "testing"
+
+func TestUnique(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "uniqueItem", Fields: []*Field{{Name: "email", Unique: true}}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	add := func(email string) error {
+		h, err := e.FromMap(ctx, map[string]interface{}{"email": email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, key := e.NewIncompleteKey(ctx)
+		_, err = e.Add(ctx, key, h)
+		return err
+	}
+
+	key := addTestItem(t, ctx, e, map[string]interface{}{"email": "a@example.com"})
+	if _, ok := add("a@example.com").(*UniqueError); !ok {
+		t.Fatal("add of used value isn't rejected with UniqueError")
+	}
+
+	// edit releases the previous value
+	if _, err := e.Patch(ctx, key, MergePatch{"email": "b@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("a@example.com"); err != nil {
+		t.Fatalf("add of released value returned %v", err)
+	}
+	if _, ok := add("b@example.com").(*UniqueError); !ok {
+		t.Fatal("add of edited value isn't rejected with UniqueError")
+	}
+
+	// delete releases the value
+	if err := e.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("b@example.com"); err != nil {
+		t.Fatalf("add of value of deleted entity returned %v", err)
+	}
+}
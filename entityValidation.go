@@ -0,0 +1,160 @@
+package sdk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// field error codes
+const (
+	CodeRequired   = "required"
+	CodeInvalid    = "invalid"
+	CodeEditDenied = "edit_denied"
+	CodeType       = "type"
+	CodeReference  = "reference" // related entity doesn't exist
+	CodeUnique     = "unique"    // value is used by another entity
+)
+
+// FieldError describes why a field value was rejected
+type FieldError struct {
+	Field   string                 `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"` // field constraints
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+func newFieldError(field *Field, code string, format string) *FieldError {
+	return &FieldError{
+		Field:   field.datastoreFieldName,
+		Code:    code,
+		Message: fmt.Sprintf(format, field.datastoreFieldName),
+	}
+}
+
+// toFieldError keeps field errors; other errors returned by field functions mean invalid value
+func toFieldError(field *Field, err error) *FieldError {
+	if fe, ok := err.(*FieldError); ok {
+		return fe
+	}
+	return &FieldError{
+		Field:   field.datastoreFieldName,
+		Code:    CodeInvalid,
+		Message: err.Error(),
+	}
+}
+
+// ValidationError collects errors of all invalid fields
+type ValidationError struct {
+	Fields map[string]*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var names []string
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var messages []string
+	for _, name := range names {
+		messages = append(messages, e.Fields[name].Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add keeps the first error of each field
+func (e *ValidationError) Add(err *FieldError) {
+	if e.Fields == nil {
+		e.Fields = map[string]*FieldError{}
+	}
+	if _, ok := e.Fields[err.Field]; !ok {
+		e.Fields[err.Field] = err
+	}
+}
+
+// add adds error returned for named input value
+func (e *ValidationError) add(name string, err error) {
+	fe, ok := err.(*FieldError)
+	if !ok {
+		fe = &FieldError{Field: name, Code: CodeInvalid, Message: err.Error()}
+	}
+	e.Add(fe)
+}
+
+// errOrNil returns nil if there are no errors so that the result can be returned as error
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fieldErrors returns errors by field of validation and unique errors and nil otherwise
+func fieldErrors(err error) map[string]*FieldError {
+	switch err := err.(type) {
+	case *ValidationError:
+		return err.Fields
+	case *UniqueError:
+		return map[string]*FieldError{err.Field: &err.FieldError}
+	}
+	return nil
+}
+
+// partialInput ignores missing required fields of edit input; existing values are kept and Save checks them again
+func partialInput(err error) error {
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		return err
+	}
+	var rest = &ValidationError{}
+	for _, fe := range verr.Fields {
+		if fe.Code != CodeRequired {
+			rest.Add(fe)
+		}
+	}
+	return rest.errOrNil()
+}
+
+// Validate checks that all required fields have values
+func (e *EntityDataHolder) Validate() error {
+	var verr = &ValidationError{}
+	e.validateRequired(verr)
+	return verr.errOrNil()
+}
+
+func (e *EntityDataHolder) validateRequired(verr *ValidationError) {
+	for _, field := range e.Entity.requiredFields {
+		if _, ok := e.data[field]; !ok {
+			verr.Add(newFieldError(field, CodeRequired, ErrFieldRequired))
+		}
+	}
+}
+
+// constraints returns constraints set on the field
+func (f *Field) constraints() map[string]interface{} {
+	var params = map[string]interface{}{}
+	if f.Min != nil {
+		params["min"] = *f.Min
+	}
+	if f.Max != nil {
+		params["max"] = *f.Max
+	}
+	if f.MinLength > 0 {
+		params["minLength"] = f.MinLength
+	}
+	if f.MaxLength > 0 {
+		params["maxLength"] = f.MaxLength
+	}
+	if f.Type == EnumType {
+		params["enum"] = f.Enum
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
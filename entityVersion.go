@@ -0,0 +1,40 @@
+package sdk
+
+import (
+	"strconv"
+	"strings"
+)
+
+// versionFieldName is special field incremented on every write
+const versionFieldName = "_version"
+
+// Version returns number of writes of the entity; zero for entities stored before versioning
+func (e *EntityDataHolder) Version() int64 {
+	v, _ := e.data[e.Entity.fields[versionFieldName]].(int64)
+	return v
+}
+
+func (e *EntityDataHolder) setVersion(v int64) {
+	if field, ok := e.Entity.fields[versionFieldName]; ok {
+		e.data[field] = v
+	}
+}
+
+// ETag returns entity tag of the entity version
+func (e *EntityDataHolder) ETag() string {
+	return `"` + strconv.FormatInt(e.Version(), 10) + `"`
+}
+
+// matches checks If-Match header value; empty value matches any entity
+func (e *EntityDataHolder) matches(ifMatch string) bool {
+	if len(ifMatch) == 0 {
+		return true
+	}
+	etag := e.ETag()
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
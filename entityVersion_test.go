@@ -0,0 +1,30 @@
+package sdk
+
+import "testing"
+
+func TestIfMatch(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := newTestEntity(t, "ifMatchItem")
+	key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a"})
+
+	h, err := e.Patch(ctx.WithIfMatch(`"1"`), key, MergePatch{"name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ETag() != `"2"` {
+		t.Fatalf("ETag is %s after edit, want \"2\"", h.ETag())
+	}
+
+	if _, err = e.Patch(ctx.WithIfMatch(`"1"`), key, MergePatch{"name": "c"}); err != ErrPreconditionFailed {
+		t.Fatalf("patch with stale If-Match returned %v, want ErrPreconditionFailed", err)
+	}
+	if err = e.Delete(ctx.WithIfMatch(`"1"`), key); err != ErrPreconditionFailed {
+		t.Fatalf("delete with stale If-Match returned %v, want ErrPreconditionFailed", err)
+	}
+	if _, err = e.Patch(ctx.WithIfMatch(`"1", "2"`), key, MergePatch{"name": "c"}); err != nil {
+		t.Fatalf("patch with one of If-Match versions returned %v", err)
+	}
+	if err = e.Delete(ctx.WithIfMatch("*"), key); err != nil {
+		t.Fatalf("delete with If-Match * returned %v", err)
+	}
+}
@@ -51,6 +51,10 @@ type MiddlewareOptions struct {
 	// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
 	// Default: nil
 	SigningMethod jwt.SigningMethod
+	// Keys select the validation key by the kid header of the token; when set ValidationKeyGetter and
+	// SigningMethod are not used and the token algorithm must be the algorithm of its key
+	// Default: nil
+	Keys KeySet
 }
 
 type JWTMiddleware struct {
@@ -234,7 +238,11 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 	// Now parse the token
 	parser := new(jwt.Parser)
 	parser.SkipClaimsValidation = true
-	parsedToken, err := parser.Parse(token, m.Options.ValidationKeyGetter)
+	keyGetter := m.Options.ValidationKeyGetter
+	if m.Options.Keys != nil {
+		keyGetter = m.Options.Keys.validationKey
+	}
+	parsedToken, err := parser.Parse(token, keyGetter)
 
 	// Check if there was an error in parsing...
 	if err != nil {
@@ -243,7 +251,7 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("Error parsing token: %v", err)
 	}
 
-	if m.Options.SigningMethod != nil && m.Options.SigningMethod.Alg() != parsedToken.Header["alg"] {
+	if m.Options.Keys == nil && m.Options.SigningMethod != nil && m.Options.SigningMethod.Alg() != parsedToken.Header["alg"] {
 		message := fmt.Sprintf("Expected %s signing method but token specified %s",
 			m.Options.SigningMethod.Alg(),
 			parsedToken.Header["alg"])
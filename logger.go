@@ -0,0 +1,10 @@
+package sdk
+
+import "google.golang.org/appengine/log"
+
+// log functions of the package; App Engine log works only with request contexts, so tests replace them
+var (
+	logDebugf = log.Debugf
+	logInfof  = log.Infof
+	logErrorf = log.Errorf
+)
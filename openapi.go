@@ -0,0 +1,251 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOperation documents a route in the OpenAPI document
+type apiOperation struct {
+	summary string
+	tag     string
+	entity  *Entity  // entity the route reads or writes
+	input   bool     // request body is entity input
+	output  string   // outputEntity, outputList or empty for any result
+	query   []string // query parameters
+}
+
+const (
+	outputEntity = "entity"
+	outputList   = "list"
+)
+
+var listQuery = []string{"sort", "limit", "offset", "cursor", "filter[0][field]", "filter[0][op]", "filter[0][value]", "expand"}
+
+// matches {name} and {name:pattern} in mux path templates
+var pathParamRgx = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// describe adds route documentation to the OpenAPI document
+func (a *SDK) describe(route *mux.Route, op *apiOperation) *mux.Route {
+	if a.operations == nil {
+		a.operations = map[*mux.Route]*apiOperation{}
+	}
+	a.operations[route] = op
+	return route
+}
+
+// OpenAPI returns OpenAPI 3.1 document of all registered routes. Schemas of entities with enabled API, of
+// entities routes read or write and of entities they look up are added as components; other entities, e.g.
+// internal ones, are left out.
+func (a *SDK) OpenAPI() (map[string]interface{}, error) {
+	var schemas = map[string]interface{}{
+		"Error": errorSchema,
+	}
+	var referenced []string // entities without schema yet
+	var ref = func(name string) string {
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil
+			referenced = append(referenced, name)
+		}
+		return "#/components/schemas/" + name
+	}
+	for _, e := range enabledEntityAPIs {
+		ref(e.Name)
+	}
+
+	var paths = map[string]map[string]interface{}{}
+	err := a.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// routes without methods accept any
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet, http.MethodPost}
+		}
+
+		op, ok := a.operations[route]
+		if !ok {
+			op = &apiOperation{}
+		}
+
+		path := pathParamRgx.ReplaceAllString(tpl, "{$1}")
+		if _, ok := paths[path]; !ok {
+			paths[path] = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			paths[path][strings.ToLower(method)] = op.document(method, path, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for len(referenced) > 0 {
+		name := referenced[0]
+		referenced = referenced[1:]
+		if e, ok := Entities[name]; ok {
+			schemas[name] = e.jsonSchema(ref)
+		} else {
+			delete(schemas, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": jsonSchemaDialect,
+		"info": map[string]interface{}{
+			"title":   "API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"bearer": []string{}},
+		},
+	}, nil
+}
+
+func (op *apiOperation) document(method string, path string, ref func(name string) string) map[string]interface{} {
+	var doc = map[string]interface{}{
+		"operationId": operationId(method, path),
+	}
+	if len(op.summary) > 0 {
+		doc["summary"] = op.summary
+	}
+	if len(op.tag) > 0 {
+		doc["tags"] = []string{op.tag}
+	}
+
+	var params []interface{}
+	for _, m := range pathParamRgx.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, name := range op.query {
+		params = append(params, map[string]interface{}{
+			"name":   name,
+			"in":     "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.input && op.entity != nil {
+		var schema = map[string]interface{}{"$ref": ref(op.entity.Name)}
+		doc["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/json":                  map[string]interface{}{"schema": schema},
+				"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema},
+			},
+		}
+	}
+
+	var result = map[string]interface{}{}
+	if op.entity != nil {
+		switch op.output {
+		case outputEntity:
+			result = map[string]interface{}{"$ref": ref(op.entity.Name)}
+		case outputList:
+			result = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"data":       map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": ref(op.entity.Name)}},
+					"count":      map[string]interface{}{"type": "integer"},
+					"nextCursor": map[string]interface{}{"type": "string"},
+				},
+			}
+		}
+	}
+
+	doc["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"status":    map[string]interface{}{"type": "integer"},
+							"token":     map[string]interface{}{"type": "object"},
+							responseKey: result,
+						},
+					},
+				},
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": ref("Error")},
+				},
+			},
+		},
+	}
+
+	return doc
+}
+
+// errorSchema describes responses written by PrintError
+var errorSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"status":  map[string]interface{}{"type": "integer"},
+		"message": map[string]interface{}{"type": "string"},
+		"errors": map[string]interface{}{
+			"type": "object",
+			"additionalProperties": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "string", "enum": []string{CodeRequired, CodeInvalid, CodeEditDenied, CodeType, CodeReference, CodeUnique}},
+					"message": map[string]interface{}{"type": "string"},
+					"params":  map[string]interface{}{"type": "object"},
+				},
+			},
+		},
+	},
+}
+
+// operationId is method followed by path segments, e.g. get_api_product_encodedKey
+func operationId(method string, path string) string {
+	parts := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.ToLower(method) + "_" + strings.Join(parts, "_")
+}
+
+func (a *SDK) handleOpenAPI() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := a.OpenAPI()
+		if err != nil {
+			printError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	}
+}
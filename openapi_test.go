@@ -0,0 +1,37 @@
+package sdk
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPISchemas(t *testing.T) {
+	author := newTestEntity(t, "openapiAuthor")
+	book := &Entity{Name: "openapiBook", Fields: []*Field{
+		{Name: "author", Type: KeyType, Entity: author.Name, Lookup: true},
+	}}
+	if _, err := book.init(); err != nil {
+		t.Fatal(err)
+	}
+	newTestEntity(t, "_openapiInternal")
+
+	a := &SDK{Router: mux.NewRouter()}
+	a.describe(a.Router.HandleFunc("/book", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet),
+		&apiOperation{summary: "Query book", entity: book, output: outputList})
+
+	doc, err := a.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{book.Name, author.Name} {
+		if schemas[name] == nil {
+			t.Fatalf("schema of %s is missing", name)
+		}
+	}
+	if _, ok := schemas["_openapiInternal"]; ok {
+		t.Fatal("schema of internal entity is published")
+	}
+}
@@ -0,0 +1,442 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/search"
+)
+
+// MaxDeliveryAttempts is the number of times an event is delivered to a failing subscriber before it is moved
+// to dead letters
+var MaxDeliveryAttempts = 10
+
+const (
+	outboxBatchSize  = 100              // events delivered by one dispatch
+	outboxLease      = time.Minute      // time a dispatcher has to deliver a claimed event
+	outboxMaxBackoff = 30 * time.Minute // longest wait between attempts
+)
+
+var errEventClaimed = errors.New("event is claimed by another dispatcher")
+
+// ChangeEvent describes a committed entity write. Before and After are stored documents of the entity; Before is
+// nil for new and After for removed entities. Op is one of revision actions.
+type ChangeEvent struct {
+	Id     string                 `json:"id"`
+	Entity string                 `json:"entity"`
+	Key    *datastore.Key         `json:"key"`
+	Op     string                 `json:"op"`
+	User   string                 `json:"user,omitempty"`
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+// Subscriber receives change events of entities it matches. Events are delivered at least once and in no
+// particular order, so Handle should be idempotent. Events that Handle keeps failing end in dead letters.
+type Subscriber struct {
+	Name   string                                         // identifies subscriber in stored events
+	Match  func(e *Entity) bool                           // nil matches all entities
+	Handle func(c context.Context, ev *ChangeEvent) error // error means the event is retried
+}
+
+var subscribers []*Subscriber
+
+// Subscribe registers subscriber of change events. Writes of matched entities run in transactions that also
+// store the event.
+func Subscribe(s *Subscriber) {
+	subscribers = append(subscribers, s)
+}
+
+func subscriber(name string) *Subscriber {
+	for _, s := range subscribers {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// outboxEntity holds events waiting for delivery; pending lists subscribers that didn't receive the event yet
+var outboxEntity = &Entity{
+	Name: "_outboxEvent",
+	Fields: []*Field{
+		{Name: "entity"},
+		{Name: "record", Type: KeyType},
+		{Name: "op"},
+		{Name: "user", NoIndex: true},
+		{Name: "before", Type: TextType},
+		{Name: "after", Type: TextType},
+		{Name: "pending", Multiple: true},
+		{Name: "attempts", Type: IntType},
+		{Name: "nextAttempt", Type: DateTimeType},
+		{Name: "lastError", Type: TextType},
+	},
+}
+
+// deadLetterEntity holds events a subscriber failed to handle MaxDeliveryAttempts times
+var deadLetterEntity = &Entity{
+	Name: "_outboxDeadLetter",
+	Fields: []*Field{
+		{Name: "entity"},
+		{Name: "record", Type: KeyType},
+		{Name: "op"},
+		{Name: "user", NoIndex: true},
+		{Name: "before", Type: TextType},
+		{Name: "after", Type: TextType},
+		{Name: "subscriber"},
+		{Name: "attempts", Type: IntType},
+		{Name: "lastError", Type: TextType},
+	},
+}
+
+func init() {
+	if _, err := outboxEntity.init(); err != nil {
+		panic(err)
+	}
+	if _, err := deadLetterEntity.init(); err != nil {
+		panic(err)
+	}
+	Subscribe(searchIndexer)
+	Subscribe(cacheInvalidator)
+}
+
+// subscriberNames returns names of subscribers matching entity
+func (e *Entity) subscriberNames() []interface{} {
+	var names []interface{}
+	for _, s := range subscribers {
+		if s.Match == nil || s.Match(e) {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+func (e *Entity) hasSubscribers() bool {
+	return len(e.subscriberNames()) > 0
+}
+
+// transactional reports whether writes need a transaction to keep markers and events consistent with the entity
+func (e *Entity) transactional() bool {
+	return len(e.uniqueFields) > 0 || e.hasSubscribers()
+}
+
+// recordChange stores revision and change event of entity write; c is the write transaction
+func (e *Entity) recordChange(c context.Context, ctx Context, key *datastore.Key, rev int64, action string, before, after map[string]interface{}) error {
+	if err := e.addRevision(c, ctx, key, rev, action, before, after); err != nil {
+		return err
+	}
+	return e.enqueue(c, ctx, key, action, before, after)
+}
+
+// enqueue stores change event for subscribers matching entity
+func (e *Entity) enqueue(c context.Context, ctx Context, key *datastore.Key, op string, before, after map[string]interface{}) error {
+	names := e.subscriberNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	var f = outboxEntity.fields
+	var h = &EntityDataHolder{Entity: outboxEntity, data: Data{
+		f["entity"]:      e.Name,
+		f["record"]:      key,
+		f["op"]:          op,
+		f["user"]:        ctx.User,
+		f["before"]:      string(beforeJSON),
+		f["after"]:       string(afterJSON),
+		f["pending"]:     names,
+		f["attempts"]:    int64(0),
+		f["nextAttempt"]: time.Now(),
+		f["_createdAt"]:  time.Now(),
+	}}
+	_, err = store.Put(c, datastore.NewIncompleteKey(c, outboxEntity.Name, nil), h)
+	return err
+}
+
+var dispatchOutbox = delay.Func("sdk.dispatchOutbox", func(c context.Context) error {
+	return DispatchEvents(c)
+})
+
+// Dispatcher starts delivery of committed events after a write; AppOptions.Dispatcher replaces the default
+// TaskDispatcher. Events a dispatcher misses are delivered by the next DispatchEvents call.
+type Dispatcher func(c context.Context) error
+
+// TaskDispatcher runs DispatchEvents in an App Engine task
+func TaskDispatcher(c context.Context) error {
+	return dispatchOutbox.Call(c)
+}
+
+// InlineDispatcher runs DispatchEvents before the write returns; it works without App Engine task queue
+func InlineDispatcher(c context.Context) error {
+	return DispatchEvents(c)
+}
+
+var dispatcher Dispatcher = TaskDispatcher
+
+// dispatchLater starts delivery of committed events; events are delivered by DispatchEvents calls otherwise
+func (e *Entity) dispatchLater(ctx Context) {
+	if !e.hasSubscribers() || dispatcher == nil {
+		return
+	}
+	if err := dispatcher(ctx.Context); err != nil {
+		logErrorf(ctx.Context, "%v", err.Error())
+	}
+}
+
+// DispatchEvents delivers events that are due. Writes start it in a task; it should also run periodically,
+// e.g. with DispatchEventsHandler as a cron job, to retry failed deliveries.
+func DispatchEvents(c context.Context) error {
+	t := store.Run(c, &StoreQuery{
+		Kind:    outboxEntity.Name,
+		Filters: []EntityQueryFilter{{Name: "nextAttempt", Operator: "<=", Value: time.Now()}},
+		Orders:  []string{"nextAttempt"},
+		Limit:   outboxBatchSize,
+	})
+	var keys []*datastore.Key
+	for {
+		k, err := t.Next(&EntityDataHolder{Entity: outboxEntity, data: Data{}})
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+
+	for _, key := range keys {
+		if err := deliver(c, key); err != nil && err != errEventClaimed && err != datastore.ErrNoSuchEntity {
+			logErrorf(c, "%v", err.Error())
+		}
+	}
+	return nil
+}
+
+// DispatchEventsHandler runs DispatchEvents for App Engine cron requests
+func DispatchEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.Error(w, ErrNotAuthorized.Error(), http.StatusForbidden)
+		return
+	}
+	ctx := NewContext(r)
+	if err := DispatchEvents(ctx.Context); err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ctx.Print(w, "success")
+}
+
+// deliver claims event and hands it to pending subscribers. Subscribers that fail keep the event pending until
+// MaxDeliveryAttempts is reached and the event is moved to dead letters.
+func deliver(c context.Context, key *datastore.Key) error {
+	var f = outboxEntity.fields
+	var h *EntityDataHolder
+	err := store.RunInTransaction(c, func(tc context.Context) error {
+		stored, err := outboxEntity.stored(tc, key)
+		if err != nil {
+			return err
+		}
+		if next, _ := stored.data[f["nextAttempt"]].(time.Time); next.After(time.Now()) {
+			return errEventClaimed
+		}
+		stored.data[f["nextAttempt"]] = time.Now().Add(outboxLease)
+		h = stored
+		_, err = store.Put(tc, key, stored)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ev := changeEvent(key, h)
+	var failed []interface{}
+	var lastErr error
+	for _, name := range fieldValues(f["pending"], h.data[f["pending"]]) {
+		s := subscriber(name.(string))
+		if s == nil {
+			// subscriber was removed
+			continue
+		}
+		if err := s.Handle(c, ev); err != nil {
+			failed = append(failed, name)
+			lastErr = err
+		}
+	}
+	if len(failed) == 0 {
+		return store.Delete(c, key)
+	}
+
+	attempts, _ := h.data[f["attempts"]].(int64)
+	attempts++
+	if attempts >= int64(MaxDeliveryAttempts) {
+		return store.RunInTransaction(c, func(tc context.Context) error {
+			for _, name := range failed {
+				if err := putDeadLetter(tc, h, name.(string), attempts, lastErr); err != nil {
+					return err
+				}
+			}
+			return store.Delete(tc, key)
+		})
+	}
+
+	h.data[f["pending"]] = failed
+	h.data[f["attempts"]] = attempts
+	h.data[f["lastError"]] = lastErr.Error()
+	h.data[f["nextAttempt"]] = time.Now().Add(backoff(attempts))
+	_, err = store.Put(c, key, h)
+	return err
+}
+
+// backoff doubles the wait after every failed attempt
+func backoff(attempts int64) time.Duration {
+	wait := 10 * time.Second
+	for i := int64(1); i < attempts && wait < outboxMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return wait
+}
+
+func changeEvent(key *datastore.Key, h *EntityDataHolder) *ChangeEvent {
+	var f = outboxEntity.fields
+	var ev = &ChangeEvent{Id: key.Encode()}
+	ev.Entity, _ = h.data[f["entity"]].(string)
+	ev.Key, _ = h.data[f["record"]].(*datastore.Key)
+	ev.Op, _ = h.data[f["op"]].(string)
+	ev.User, _ = h.data[f["user"]].(string)
+	ev.Time, _ = h.data[f["_createdAt"]].(time.Time)
+	if s, ok := h.data[f["before"]].(string); ok {
+		json.Unmarshal([]byte(s), &ev.Before)
+	}
+	if s, ok := h.data[f["after"]].(string); ok {
+		json.Unmarshal([]byte(s), &ev.After)
+	}
+	return ev
+}
+
+func putDeadLetter(c context.Context, h *EntityDataHolder, name string, attempts int64, lastErr error) error {
+	var f = outboxEntity.fields
+	var d = deadLetterEntity.fields
+	var dead = &EntityDataHolder{Entity: deadLetterEntity, data: Data{
+		d["entity"]:     h.data[f["entity"]],
+		d["record"]:     h.data[f["record"]],
+		d["op"]:         h.data[f["op"]],
+		d["user"]:       h.data[f["user"]],
+		d["before"]:     h.data[f["before"]],
+		d["after"]:      h.data[f["after"]],
+		d["subscriber"]: name,
+		d["attempts"]:   attempts,
+		d["lastError"]:  lastErr.Error(),
+		d["_createdAt"]: h.data[f["_createdAt"]],
+	}}
+	_, err := store.Put(c, datastore.NewIncompleteKey(c, deadLetterEntity.Name, nil), dead)
+	return err
+}
+
+// Redeliver moves dead letter back to outbox; its subscriber gets MaxDeliveryAttempts new attempts
+func Redeliver(c context.Context, key *datastore.Key) error {
+	return store.RunInTransaction(c, func(tc context.Context) error {
+		dead, err := deadLetterEntity.stored(tc, key)
+		if err != nil {
+			return err
+		}
+		var f = outboxEntity.fields
+		var d = deadLetterEntity.fields
+		var h = &EntityDataHolder{Entity: outboxEntity, data: Data{
+			f["entity"]:      dead.data[d["entity"]],
+			f["record"]:      dead.data[d["record"]],
+			f["op"]:          dead.data[d["op"]],
+			f["user"]:        dead.data[d["user"]],
+			f["before"]:      dead.data[d["before"]],
+			f["after"]:       dead.data[d["after"]],
+			f["pending"]:     []interface{}{dead.data[d["subscriber"]]},
+			f["attempts"]:    int64(0),
+			f["nextAttempt"]: time.Now(),
+			f["_createdAt"]:  dead.data[d["_createdAt"]],
+		}}
+		if _, err := store.Put(tc, datastore.NewIncompleteKey(tc, outboxEntity.Name, nil), h); err != nil {
+			return err
+		}
+		return store.Delete(tc, key)
+	})
+}
+
+// searchIndexer keeps search documents of entities with indexes in sync with the stored entity
+var searchIndexer = &Subscriber{
+	Name: "search",
+	Match: func(e *Entity) bool {
+		return len(e.indexes) > 0
+	},
+	Handle: func(c context.Context, ev *ChangeEvent) error {
+		e, ok := Entities[ev.Entity]
+		if !ok {
+			return nil
+		}
+		id := ev.Key.Encode()
+
+		h, err := e.stored(c, ev.Key)
+		if err == datastore.ErrNoSuchEntity || (err == nil && h.isDeleted()) {
+			for _, dd := range e.indexes {
+				if err := dd.Delete(c, id); err != nil && err != search.ErrNoSuchDocument {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := e.addGeoPoint(h); err != nil {
+			return err
+		}
+		for _, dd := range e.indexes {
+			if err := dd.Put(c, id, flatOutput(id, h.data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// cacheInvalidator removes entities cached by Lookup or CacheData after they change
+var cacheInvalidator = &Subscriber{
+	Name: "cache",
+	Match: func(e *Entity) bool {
+		if e.Cache.CacheOnWrite {
+			return true
+		}
+		for _, r := range Entities {
+			for _, field := range r.fields {
+				if field.Lookup && field.Entity == e.Name {
+					return true
+				}
+			}
+		}
+		return false
+	},
+	Handle: func(c context.Context, ev *ChangeEvent) error {
+		err := memcache.Delete(c, ev.Key.Encode())
+		if err == memcache.ErrCacheMiss {
+			return nil
+		}
+		return err
+	},
+}
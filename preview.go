@@ -7,5 +7,6 @@ import "net/http"
 func (a *SDK) Render(domain string, dir string) {
 	fs := http.FileServer(http.Dir(dir))
 	//http.Handle("/preview/", http.StripPrefix("/preview", fs))
-	http.Handle("/", http.StripPrefix("/", fs))
+	// files are served where no rendered page matches
+	a.pages().NotFoundHandler = http.StripPrefix("/", fs)
 }
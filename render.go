@@ -1,10 +1,128 @@
 package sdk
 
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/appengine/datastore"
+)
+
 type Render struct {
 	Path    string                                                              `json:"path"` // "https://domain.com/:category/:id", "domain.com/other/{name}", ...
 	URLFunc func(c Context, r Render, h *EntityDataHolder) (interface{}, error) `json:"-"`
 }
 
+var ErrRenderPathNotValid = errors.New("render path needs :slug or :id segment")
+
+// route returns host and mux path template of Render.Path; :name segments become {name} variables
+func (r Render) route() (string, string) {
+	var host, p = "", r.Path
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+3:]
+	}
+	if !strings.HasPrefix(p, "/") {
+		if i := strings.Index(p, "/"); i >= 0 {
+			host, p = p[:i], p[i:]
+		} else {
+			host, p = p, "/"
+		}
+	}
+
+	var segments = strings.Split(p, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return host, strings.Join(segments, "/")
+}
+
+// pages returns router of rendered pages served at site root
+func (a *SDK) pages() *mux.Router {
+	if a.pageRouter == nil {
+		a.pageRouter = mux.NewRouter()
+		http.Handle("/", a.pageRouter)
+	}
+	return a.pageRouter
+}
+
+// HandleRender serves pages of entity at its Render.Path. Entity is found by :slug segment with BySlug or by
+// :id segment holding encoded key; previous slugs are redirected to the current one. Page is executed with
+// entity output and rendered in layout if it is not nil.
+func (a *SDK) HandleRender(e *Entity, page *Page, layout *Page) *mux.Route {
+	host, p := e.Render.route()
+	if !strings.Contains(p, "{slug}") && !strings.Contains(p, "{id}") {
+		panic(ErrRenderPathNotValid)
+	}
+
+	route := a.pages().HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r)
+		vars := mux.Vars(r)
+
+		var h *EntityDataHolder
+		var err error
+		if slug, ok := vars["slug"]; ok {
+			var redirect bool
+			h, redirect, err = e.BySlug(ctx, slug)
+			if err == nil && redirect {
+				a.redirectSlug(w, r, vars, h.data[e.slugFields[0]])
+				return
+			}
+		} else {
+			var key *datastore.Key
+			if ctx, key, err = e.DecodeKey(ctx, vars["id"]); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			h, err = e.Get(ctx, key)
+		}
+		if err == datastore.ErrNoSuchEntity {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err == ErrNotAuthorized {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if layout != nil {
+			page.RenderInLayout(w, layout, h.Output(ctx))
+			return
+		}
+		page.Render(w, h.Output(ctx))
+	}).Methods(http.MethodGet)
+	if len(host) != 0 {
+		route.Host(host)
+	}
+	return route
+}
+
+// redirectSlug redirects to the page path with current slug of entity
+func (a *SDK) redirectSlug(w http.ResponseWriter, r *http.Request, vars map[string]string, slug interface{}) {
+	var pairs []string
+	for name, value := range vars {
+		if name == "slug" {
+			value, _ = slug.(string)
+		}
+		pairs = append(pairs, name, value)
+	}
+	location, err := mux.CurrentRoute(r).URLPath(pairs...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(r.URL.RawQuery) != 0 {
+		location.RawQuery = r.URL.RawQuery
+	}
+	http.Redirect(w, r, location.String(), http.StatusMovedPermanently)
+}
+
 /*func (a *SDK) publish(r *http.Request) {
 	ctx := NewContext(r)
 
@@ -3,7 +3,6 @@ package sdk
 import (
 	"encoding/json"
 	"fmt"
-	"google.golang.org/appengine/log"
 	"net/http"
 )
 
@@ -12,23 +11,24 @@ const responseKey = "result"
 type Result map[string]interface{}
 
 func printError(w http.ResponseWriter, err error, code int) {
-	write(w, Token{}, code, err.Error(), responseKey, nil)
+	write(w, Token{}, code, err, responseKey, nil)
 }
 
 func printData(w http.ResponseWriter, response interface{}) {
-	write(w, Token{}, http.StatusOK, "", responseKey, response)
+	write(w, Token{}, http.StatusOK, nil, responseKey, response)
 }
 
 func (c *Context) Print(w http.ResponseWriter, response interface{}) {
-	write(w, c.Token, http.StatusOK, "", responseKey, response)
+	write(w, c.Token, http.StatusOK, nil, responseKey, response)
 }
 
 func (c *Context) PrintError(w http.ResponseWriter, err error, code int) {
-	log.Errorf(c.Context, "Internal Error: %v", err)
-	write(w, c.Token, code, err.Error(), responseKey, nil)
+	logErrorf(c.Context, "Internal Error: %v", err)
+	write(w, c.Token, code, err, responseKey, nil)
 }
 
-func write(w http.ResponseWriter, token Token, status int, message string, responseKey string, response interface{}) {
+// write prints response; validation errors are printed by field under "errors"
+func write(w http.ResponseWriter, token Token, status int, err error, responseKey string, response interface{}) {
 	var out = Result{
 		"status": status,
 	}
@@ -37,8 +37,12 @@ func write(w http.ResponseWriter, token Token, status int, message string, respo
 		out["token"] = token
 	}
 
-	if len(message) > 0 {
-		out["message"] = message
+	if err != nil {
+		out["message"] = err.Error()
+
+		if errs := fieldErrors(err); errs != nil {
+			out["errors"] = errs
+		}
 	}
 
 	if response != nil {
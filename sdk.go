@@ -8,6 +8,7 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
+	"html/template"
 	"net/http"
 )
 
@@ -17,11 +18,27 @@ type SDK struct {
 	middleware   *JWTMiddleware
 	sessionStore *sessions.CookieStore
 	installed    bool
+	operations   map[*mux.Route]*apiOperation // route documentation
+	pageRouter   *mux.Router                  // rendered pages; see HandleRender
 }
 
 type AppOptions struct {
-	SigningKey []byte
-	AdminEmail string
+	SigningKey []byte     // HS256 secret; with Keys it only verifies tokens without kid
+	Keys       KeySet     // keys that sign and verify tokens; defaults to SigningKey
+	AdminEmail string     // sender of emails
+	Store      Store      // storage backend; defaults to Datastore
+	Dispatcher Dispatcher // starts delivery of change events after writes; defaults to TaskDispatcher
+
+	PasswordResetURL      string             // page that sets new password; reset emails link to it with token parameter
+	PasswordResetTemplate *template.Template // template "email" of reset emails; executed with Link and Expires
+
+	VerifyEmail          bool               // registration emails verification link; login of unverified users is refused
+	UnverifiedRole       Role               // if set, unverified users log in with this role instead of being refused
+	VerificationURL      string             // page that verifies email with token parameter; required with VerifyEmail
+	VerificationTemplate *template.Template // template "email" of verification emails; executed with Link and Expires
+
+	TwoFactorRoles  []Role // roles that must log in with two-factor authentication
+	TwoFactorIssuer string // account issuer shown in authenticator apps; defaults to app id
 }
 
 type Config struct {
@@ -36,15 +53,14 @@ func init() {
 	gob.Register(appengine.GeoPoint{})
 }
 
-var signingKey []byte
-
 func (s *MyServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if origin := req.Header.Get("Origin"); origin != "" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers",
 			"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Cache-Control, "+
-				"X-Requested-With")
+				"X-Requested-With, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 	}
 	if req.Method == "OPTIONS" {
 		return
@@ -64,21 +80,42 @@ func NewApp(opt AppOptions) *SDK {
 		AppOptions: &opt,
 	}
 
-	if opt.SigningKey == nil {
+	if opt.SigningKey == nil && len(opt.Keys) == 0 {
 		opt.SigningKey = securecookie.GenerateRandomKey(64)
 	}
 
-	signingKey = opt.SigningKey
+	if len(opt.Keys) == 0 {
+		signingKeys = KeySet{{Algorithm: HS256, Key: opt.SigningKey}}
+	} else {
+		signingKeys = append(KeySet{}, opt.Keys...)
+		if opt.SigningKey != nil {
+			// tokens signed before keys were set have no kid
+			signingKeys = append(signingKeys, &JWTKey{Algorithm: HS256, Key: opt.SigningKey, VerifyOnly: true})
+		}
+	}
+	if _, err := signingKeys.validate(); err != nil {
+		panic(err)
+	}
+
+	if opt.Store != nil {
+		store = opt.Store
+	}
+	if opt.Dispatcher != nil {
+		dispatcher = opt.Dispatcher
+	}
 
 	a.Router = mux.NewRouter().PathPrefix(apiPath).Subrouter()
-	a.middleware = AuthMiddleware(signingKey)
+	a.middleware = AuthMiddlewareWithKeys(signingKeys)
 	http.Handle(apiPath, &MyServer{a.Router})
 
 	// handler returns enabled apis
-	a.HandleFunc("/entities", func(w http.ResponseWriter, r *http.Request) {
+	a.describe(a.HandleFunc("/entities", func(w http.ResponseWriter, r *http.Request) {
 		ctx := NewContext(r)
 		ctx.Print(w, enabledEntityAPIs)
-	})
+	}), &apiOperation{summary: "Enabled entities"})
+
+	a.describe(a.HandleFunc("/openapi.json", a.handleOpenAPI()).Methods(http.MethodGet), &apiOperation{summary: "OpenAPI document"})
+	a.describe(a.HandleFunc("/.well-known/jwks.json", JWKSHandler).Methods(http.MethodGet), &apiOperation{summary: "Public keys of tokens", tag: "auth"})
 
 	// client handler
 	if _, err := clientIdSecret.init(); err != nil {
@@ -105,13 +142,59 @@ func (a *SDK) EnableAuthAPI() {
 	if _, err := ProfileEntity.init(); err != nil {
 		panic(err)
 	}
-
-	a.HandleFunc("/profile", GetUserProfileHandler).Methods(http.MethodGet)
-	a.HandleFunc("/profile", EditUserProfileHandler).Methods(http.MethodPut)
-
-	a.HandleFunc("/auth/login", LoginHandler).Methods(http.MethodPost)
-	a.HandleFunc("/auth/register", RegisterHandler).Methods(http.MethodPost)
-	a.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+	if _, err := refreshTokenEntity.init(); err != nil {
+		panic(err)
+	}
+	if a.PasswordResetTemplate != nil {
+		passwordResetTemplate = a.PasswordResetTemplate
+	}
+	if a.VerificationTemplate != nil {
+		verificationTemplate = a.VerificationTemplate
+	}
+	if a.VerifyEmail && len(a.VerificationURL) == 0 {
+		panic(ErrVerificationNotConfigured)
+	}
+	verifyEmail, unverifiedRole, verificationURL = a.VerifyEmail, a.UnverifiedRole, a.VerificationURL
+	emailSender = a.AdminEmail
+	if _, err := twoFactorEntity.init(); err != nil {
+		panic(err)
+	}
+	twoFactorRoles = map[Role]bool{}
+	for _, role := range a.TwoFactorRoles {
+		twoFactorRoles[role] = true
+	}
+	twoFactorIssuer = a.TwoFactorIssuer
+
+	a.describe(a.HandleFunc("/profile", GetUserProfileHandler).Methods(http.MethodGet),
+		&apiOperation{summary: "User profile", tag: "auth", entity: ProfileEntity, output: outputEntity})
+	a.describe(a.HandleFunc("/profile", EditUserProfileHandler).Methods(http.MethodPut),
+		&apiOperation{summary: "Edit user profile", tag: "auth", entity: ProfileEntity, input: true, output: outputEntity})
+
+	a.describe(a.HandleFunc("/auth/login", LoginHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Log in with email and password", tag: "auth", entity: userEntity, input: true})
+	a.describe(a.HandleFunc("/auth/register", RegisterHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Register user with profile", tag: "auth", entity: userEntity, input: true})
+	a.describe(a.HandleFunc("/auth/password/forgot", ForgotPasswordHandler(a)).Methods(http.MethodPost),
+		&apiOperation{summary: "Email password reset link", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/password/reset", ResetPasswordHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Set password with reset token", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/verify", VerifyEmailHandler).Methods(http.MethodGet),
+		&apiOperation{summary: "Verify email with token from verification email", tag: "auth", query: []string{"token"}})
+	a.describe(a.HandleFunc("/auth/verify/resend", ResendVerificationHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Email new verification link", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/2fa/setup", SetupTwoFactorHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Start two-factor setup and get otpauth URI", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/2fa/confirm", ConfirmTwoFactorHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Enable two-factor authentication with code and get recovery codes", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/2fa/verify", VerifyTwoFactorHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Exchange mfa token and code for token", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/refresh", RefreshHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Exchange refresh token for new tokens", tag: "auth"})
+	a.describe(a.HandleFunc("/auth/logout", LogoutHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Revoke session of refresh token or all sessions with all=true", tag: "auth", query: []string{"all"}})
+	a.describe(a.HandleFunc("/auth/users/{encodedKey}/revoke", RevokeUserSessionsHandler).Methods(http.MethodPost),
+		&apiOperation{summary: "Revoke all sessions of user", tag: "auth"})
+	a.describe(a.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
 		ctx := NewContext(r)
 		if ctx.err != nil {
 			ctx.PrintError(w, ctx.err, http.StatusInternalServerError)
@@ -123,10 +206,10 @@ func (a *SDK) EnableAuthAPI() {
 		}
 
 		ctx.PrintError(w, ErrNotAuthenticated, http.StatusInternalServerError)
-	}).Methods(http.MethodPost)
+	}).Methods(http.MethodPost), &apiOperation{summary: "Check authentication", tag: "auth"})
 
 	// authorize and get user profile
-	a.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+	a.describe(a.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
 		ctx := NewContext(r).WithScopes(ScopeRead)
 		if ctx.err != nil {
 			ctx.PrintError(w, ctx.err, http.StatusInternalServerError)
@@ -151,7 +234,7 @@ func (a *SDK) EnableAuthAPI() {
 		}
 
 		ctx.PrintError(w, ErrNotAuthenticated, http.StatusUnauthorized)
-	}).Methods(http.MethodGet)
+	}).Methods(http.MethodGet), &apiOperation{summary: "Authenticated user profile", tag: "auth", entity: ProfileEntity, output: outputEntity})
 }
 
 func (a *SDK) Handle(path string, handler http.Handler) *mux.Route {
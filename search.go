@@ -110,6 +110,62 @@ func (dd *DocumentDefinition) Put(ctx context.Context, id string, data map[strin
 	return err
 }
 
+// searchBatchSize is the most documents search API accepts in one call
+const searchBatchSize = 200
+
+// PutMulti puts documents in batches
+func (dd *DocumentDefinition) PutMulti(ctx context.Context, ids []string, data []map[string]interface{}) error {
+	index, err := search.Open(dd.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(ids); i += searchBatchSize {
+		end := i + searchBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var docs []interface{}
+		for j := i; j < end; j++ {
+			assembled := dd.Assemble(ids[j], data[j])
+			docs = append(docs, &assembled)
+		}
+		if _, err = index.PutMulti(ctx, ids[i:end], docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMulti deletes documents in batches
+func (dd *DocumentDefinition) DeleteMulti(ctx context.Context, ids []string) error {
+	index, err := search.Open(dd.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(ids); i += searchBatchSize {
+		end := i + searchBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err = index.DeleteMulti(ctx, ids[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dd *DocumentDefinition) Delete(ctx context.Context, id string) error {
+	index, err := search.Open(dd.Name)
+	if err != nil {
+		return err
+	}
+
+	return index.Delete(ctx, id)
+}
+
 func (dd *DocumentDefinition) Assemble(id string, data map[string]interface{}) Document {
 	var document = Document{}
 
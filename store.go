@@ -0,0 +1,113 @@
+package sdk
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Store is the storage backend entities are persisted with. Implementations share keys, properties and
+// sentinel errors (datastore.Done, datastore.ErrNoSuchEntity) with the datastore package, so EntityDataHolder
+// Load/Save works the same way with every backend.
+type Store interface {
+	Get(ctx context.Context, key *datastore.Key, dst datastore.PropertyLoadSaver) error
+	GetMulti(ctx context.Context, keys []*datastore.Key, dst []datastore.PropertyLoadSaver) error
+	Put(ctx context.Context, key *datastore.Key, src datastore.PropertyLoadSaver) (*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+
+	// PutMulti and DeleteMulti return appengine.MultiError if some of the entities failed
+	PutMulti(ctx context.Context, keys []*datastore.Key, src []datastore.PropertyLoadSaver) ([]*datastore.Key, error)
+	DeleteMulti(ctx context.Context, keys []*datastore.Key) error
+	Run(ctx context.Context, q *StoreQuery) StoreIterator
+
+	// RunInTransaction runs f in a transaction; operations must use the context passed to f
+	RunInTransaction(ctx context.Context, f func(tc context.Context) error) error
+}
+
+// StoreQuery describes a query over a single kind
+type StoreQuery struct {
+	Kind    string
+	Filters []EntityQueryFilter
+	Orders  []string // field names; prefixed with "-" for descending order
+	Limit   int      // zero means no limit
+	Offset  int
+	Cursor  string   // if set, results continue after the position returned by StoreIterator.Cursor
+	Project []string // if set, only the named properties are loaded
+}
+
+// StoreIterator is the result of a query. Next returns datastore.Done when there are no more results.
+type StoreIterator interface {
+	Next(dst datastore.PropertyLoadSaver) (*datastore.Key, error)
+
+	// Cursor returns an opaque cursor pointing after the last result returned by Next
+	Cursor() (string, error)
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const offsetCursorPrefix = "offset:"
+
+// store is the backend used by all entities; configured with AppOptions.Store
+var store Store = NewDatastoreStore()
+
+// storeItem is a loaded entity used by stores that iterate over in-memory results
+type storeItem struct {
+	key   *datastore.Key
+	props []datastore.Property
+}
+
+type sliceIterator struct {
+	items  []storeItem
+	err    error
+	i      int
+	offset int // position of the first item in the full result
+}
+
+func (t *sliceIterator) Next(dst datastore.PropertyLoadSaver) (*datastore.Key, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.i >= len(t.items) {
+		return nil, datastore.Done
+	}
+	item := t.items[t.i]
+	t.i++
+	return item.key, dst.Load(copyProperties(item.props))
+}
+
+func (t *sliceIterator) Cursor() (string, error) {
+	if t.err != nil {
+		return "", t.err
+	}
+	return encodeOffsetCursor(t.offset + t.i), nil
+}
+
+// encodeOffsetCursor is the cursor of stores that page by position
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(offsetCursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeOffsetCursor(cursor string) (int, error) {
+	if len(cursor) == 0 {
+		return 0, nil
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(bs), offsetCursorPrefix) {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(bs[len(offsetCursorPrefix):]))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+func copyProperties(ps []datastore.Property) []datastore.Property {
+	var cp = make([]datastore.Property, len(ps))
+	copy(cp, ps)
+	return cp
+}
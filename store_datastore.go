@@ -0,0 +1,175 @@
+package sdk
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// DatastoreStore persists entities with App Engine Datastore
+type DatastoreStore struct{}
+
+func NewDatastoreStore() *DatastoreStore {
+	return &DatastoreStore{}
+}
+
+func (s *DatastoreStore) Get(ctx context.Context, key *datastore.Key, dst datastore.PropertyLoadSaver) error {
+	return datastore.Get(ctx, key, dst)
+}
+
+func (s *DatastoreStore) GetMulti(ctx context.Context, keys []*datastore.Key, dst []datastore.PropertyLoadSaver) error {
+	return datastore.GetMulti(ctx, keys, dst)
+}
+
+func (s *DatastoreStore) Put(ctx context.Context, key *datastore.Key, src datastore.PropertyLoadSaver) (*datastore.Key, error) {
+	return datastore.Put(ctx, key, src)
+}
+
+func (s *DatastoreStore) Delete(ctx context.Context, key *datastore.Key) error {
+	return datastore.Delete(ctx, key)
+}
+
+func (s *DatastoreStore) PutMulti(ctx context.Context, keys []*datastore.Key, src []datastore.PropertyLoadSaver) ([]*datastore.Key, error) {
+	return datastore.PutMulti(ctx, keys, src)
+}
+
+func (s *DatastoreStore) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// Run runs the query. Datastore has no != and in operators, so queries using them are split into several
+// queries whose results are merged, sorted and paged in memory.
+func (s *DatastoreStore) Run(ctx context.Context, sq *StoreQuery) StoreIterator {
+	sets := expandFilters(sq.Filters)
+	if len(sets) == 1 && len(sets[0]) == len(sq.Filters) {
+		return s.run(ctx, sq)
+	}
+
+	start, err := decodeOffsetCursor(sq.Cursor)
+	if err != nil {
+		return &sliceIterator{err: err}
+	}
+	start += sq.Offset
+
+	var seen = map[string]bool{}
+	var items []storeItem
+	for _, filters := range sets {
+		q := &StoreQuery{
+			Kind:    sq.Kind,
+			Filters: filters,
+			Orders:  sq.Orders,
+			Project: sq.Project,
+		}
+		if sq.Limit != 0 {
+			q.Limit = start + sq.Limit
+		}
+
+		t := s.run(ctx, q)
+		for {
+			var ps datastore.PropertyList
+			key, err := t.Next(&ps)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				return &sliceIterator{err: err}
+			}
+			if encoded := key.Encode(); !seen[encoded] {
+				seen[encoded] = true
+				items = append(items, storeItem{key: key, props: ps})
+			}
+		}
+	}
+
+	items = sortItems(items, sq.Orders)
+
+	return &sliceIterator{items: pageItems(items, start, sq.Limit), offset: start}
+}
+
+// expandFilters replaces != with < and > and in with = for each value, returning every combination
+func expandFilters(filters []EntityQueryFilter) [][]EntityQueryFilter {
+	var sets = [][]EntityQueryFilter{nil}
+	for _, filter := range filters {
+		var alternatives []EntityQueryFilter
+		switch filter.Operator {
+		case "!=":
+			alternatives = []EntityQueryFilter{
+				{Name: filter.Name, Operator: "<", Value: filter.Value},
+				{Name: filter.Name, Operator: ">", Value: filter.Value},
+			}
+		case "in":
+			values, _ := filter.Value.([]interface{})
+			for _, v := range values {
+				alternatives = append(alternatives, EntityQueryFilter{Name: filter.Name, Operator: "=", Value: v})
+			}
+		default:
+			alternatives = []EntityQueryFilter{filter}
+		}
+
+		var next [][]EntityQueryFilter
+		for _, set := range sets {
+			for _, alternative := range alternatives {
+				next = append(next, append(append([]EntityQueryFilter{}, set...), alternative))
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+func (s *DatastoreStore) run(ctx context.Context, sq *StoreQuery) StoreIterator {
+	q := datastore.NewQuery(sq.Kind)
+
+	for _, filter := range sq.Filters {
+		if len(filter.Name) > 0 && len(filter.Operator) > 0 {
+			q = q.Filter(fmt.Sprintf("%s %s", filter.Name, filter.Operator), filter.Value)
+		}
+	}
+
+	for _, order := range sq.Orders {
+		q = q.Order(order)
+	}
+
+	if len(sq.Project) > 0 {
+		q = q.Project(sq.Project...)
+	}
+
+	if sq.Limit != 0 {
+		q = q.Limit(sq.Limit)
+	}
+
+	q = q.Offset(sq.Offset)
+
+	if len(sq.Cursor) != 0 {
+		cursor, err := datastore.DecodeCursor(sq.Cursor)
+		if err != nil {
+			return &sliceIterator{err: ErrInvalidCursor}
+		}
+		q = q.Start(cursor)
+	}
+
+	return &datastoreIterator{q.Run(ctx)}
+}
+
+// RunInTransaction runs cross-group transactions so that batches of entities can be written together;
+// datastore allows up to 25 entity groups in a transaction
+func (s *DatastoreStore) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	return datastore.RunInTransaction(ctx, f, &datastore.TransactionOptions{XG: true})
+}
+
+type datastoreIterator struct {
+	t *datastore.Iterator
+}
+
+func (t *datastoreIterator) Next(dst datastore.PropertyLoadSaver) (*datastore.Key, error) {
+	return t.t.Next(dst)
+}
+
+func (t *datastoreIterator) Cursor() (string, error) {
+	cursor, err := t.t.Cursor()
+	if err != nil {
+		return "", err
+	}
+	return cursor.String(), nil
+}
@@ -0,0 +1,624 @@
+package sdk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// MemoryStore keeps entities in process memory. It's meant for tests and local development and mimics
+// Datastore semantics: filters and orders skip entities without the property, multi-valued properties
+// match if any value matches and transactions fail with datastore.ErrConcurrentTransaction on conflict.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	records  map[string]*memoryRecord // by encoded key
+	versions map[string]int64         // by encoded key; survives deletes so transactions notice them
+	seq      int64
+	lastID   int64
+}
+
+type memoryRecord struct {
+	key   *datastore.Key
+	props []datastore.Property // nil marks a delete staged in a transaction
+}
+
+type memoryTx struct {
+	reads  map[string]int64
+	writes map[string]*memoryRecord
+}
+
+var errMemoryOperator = errors.New("memory store: unsupported filter operator")
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records:  map[string]*memoryRecord{},
+		versions: map[string]int64{},
+	}
+}
+
+func (s *MemoryStore) tx(ctx context.Context) *memoryTx {
+	tx, _ := ctx.Value(s).(*memoryTx)
+	return tx
+}
+
+func (s *MemoryStore) lookup(ctx context.Context, encoded string) ([]datastore.Property, bool) {
+	tx := s.tx(ctx)
+	if tx != nil {
+		if r, ok := tx.writes[encoded]; ok {
+			return r.props, r.props != nil
+		}
+	}
+
+	s.mu.RLock()
+	r, ok := s.records[encoded]
+	version := s.versions[encoded]
+	s.mu.RUnlock()
+
+	if tx != nil {
+		tx.reads[encoded] = version
+	}
+	if !ok {
+		return nil, false
+	}
+	return r.props, true
+}
+
+func (s *MemoryStore) write(ctx context.Context, key *datastore.Key, props []datastore.Property) {
+	encoded := key.Encode()
+	if tx := s.tx(ctx); tx != nil {
+		tx.writes[encoded] = &memoryRecord{key: key, props: props}
+		return
+	}
+
+	s.mu.Lock()
+	s.apply(encoded, &memoryRecord{key: key, props: props})
+	s.mu.Unlock()
+}
+
+// apply must be called with s.mu held
+func (s *MemoryStore) apply(encoded string, r *memoryRecord) {
+	s.seq++
+	s.versions[encoded] = s.seq
+	if r.props == nil {
+		delete(s.records, encoded)
+	} else {
+		s.records[encoded] = r
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key *datastore.Key, dst datastore.PropertyLoadSaver) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	props, ok := s.lookup(ctx, key.Encode())
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	return dst.Load(copyProperties(props))
+}
+
+func (s *MemoryStore) GetMulti(ctx context.Context, keys []*datastore.Key, dst []datastore.PropertyLoadSaver) error {
+	if len(keys) != len(dst) {
+		return errors.New("memory store: key and dst slices have different length")
+	}
+
+	var multiErr = make(appengine.MultiError, len(keys))
+	var failed bool
+	for i, key := range keys {
+		if multiErr[i] = s.Get(ctx, key, dst[i]); multiErr[i] != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return multiErr
+	}
+	return nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key *datastore.Key, src datastore.PropertyLoadSaver) (*datastore.Key, error) {
+	if key == nil {
+		return nil, datastore.ErrInvalidKey
+	}
+
+	props, err := src.Save()
+	if err != nil {
+		return key, err
+	}
+	props, err = normalizeMemoryProperties(props)
+	if err != nil {
+		return key, err
+	}
+
+	if key.Incomplete() {
+		nctx, err := appengine.Namespace(ctx, key.Namespace())
+		if err != nil {
+			return key, err
+		}
+		key = datastore.NewKey(nctx, key.Kind(), "", atomic.AddInt64(&s.lastID, 1), key.Parent())
+	}
+
+	s.write(ctx, key, props)
+	return key, nil
+}
+
+// PutMulti puts entities in a transaction
+func (s *MemoryStore) PutMulti(ctx context.Context, keys []*datastore.Key, src []datastore.PropertyLoadSaver) ([]*datastore.Key, error) {
+	if len(keys) != len(src) {
+		return nil, errors.New("memory store: key and src slices have different length")
+	}
+
+	var putKeys = make([]*datastore.Key, len(keys))
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		var multiErr = make(appengine.MultiError, len(keys))
+		var failed bool
+		for i, key := range keys {
+			if putKeys[i], multiErr[i] = s.Put(tc, key, src[i]); multiErr[i] != nil {
+				failed = true
+			}
+		}
+		if failed {
+			return multiErr
+		}
+		return nil
+	})
+	return putKeys, err
+}
+
+// DeleteMulti deletes entities in a transaction
+func (s *MemoryStore) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		var multiErr = make(appengine.MultiError, len(keys))
+		var failed bool
+		for i, key := range keys {
+			if multiErr[i] = s.Delete(tc, key); multiErr[i] != nil {
+				failed = true
+			}
+		}
+		if failed {
+			return multiErr
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key *datastore.Key) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	s.write(ctx, key, nil)
+	return nil
+}
+
+func (s *MemoryStore) Run(ctx context.Context, q *StoreQuery) StoreIterator {
+	namespace := datastore.NewIncompleteKey(ctx, q.Kind, nil).Namespace()
+	tx := s.tx(ctx)
+
+	var items []storeItem
+	s.mu.RLock()
+	for encoded, r := range s.records {
+		if r.key.Kind() != q.Kind || r.key.Namespace() != namespace {
+			continue
+		}
+		if tx != nil {
+			if _, ok := tx.writes[encoded]; ok {
+				continue
+			}
+		}
+		items = append(items, storeItem{key: r.key, props: r.props})
+	}
+	s.mu.RUnlock()
+
+	if tx != nil {
+		for _, r := range tx.writes {
+			if r.props != nil && r.key.Kind() == q.Kind && r.key.Namespace() == namespace {
+				items = append(items, storeItem{key: r.key, props: r.props})
+			}
+		}
+	}
+
+	start, err := decodeOffsetCursor(q.Cursor)
+	if err != nil {
+		return &sliceIterator{err: err}
+	}
+	start += q.Offset
+
+	items, err = filterItems(items, q.Filters)
+	if err != nil {
+		return &sliceIterator{err: err}
+	}
+	items = sortItems(items, q.Orders)
+
+	if len(q.Project) > 0 {
+		items = projectItems(items, q.Project)
+	}
+
+	return &sliceIterator{items: pageItems(items, start, q.Limit), offset: start}
+}
+
+func (s *MemoryStore) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	// operations of a nested transaction become part of the running one
+	if s.tx(ctx) != nil {
+		return f(ctx)
+	}
+
+	for i := 0; i < 3; i++ {
+		tx := &memoryTx{
+			reads:  map[string]int64{},
+			writes: map[string]*memoryRecord{},
+		}
+		if err := f(context.WithValue(ctx, s, tx)); err != nil {
+			return err
+		}
+		if err := s.commit(tx); err != datastore.ErrConcurrentTransaction {
+			return err
+		}
+	}
+	return datastore.ErrConcurrentTransaction
+}
+
+func (s *MemoryStore) commit(tx *memoryTx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for encoded, version := range tx.reads {
+		if s.versions[encoded] != version {
+			return datastore.ErrConcurrentTransaction
+		}
+	}
+	for encoded, r := range tx.writes {
+		s.apply(encoded, r)
+	}
+	return nil
+}
+
+// normalizeMemoryProperties rejects the values Datastore would reject and truncates times to Datastore precision
+func normalizeMemoryProperties(ps []datastore.Property) ([]datastore.Property, error) {
+	var multiple = map[string]bool{}
+	var out = make([]datastore.Property, len(ps))
+
+	for i, p := range ps {
+		if m, ok := multiple[p.Name]; ok && (!m || !p.Multiple) {
+			return nil, fmt.Errorf("datastore: multiple Properties with Name %q, but Multiple is false", p.Name)
+		}
+		multiple[p.Name] = p.Multiple
+
+		switch v := p.Value.(type) {
+		case nil, int64, bool, string, float64, *datastore.Key, appengine.GeoPoint, appengine.BlobKey:
+		case time.Time:
+			p.Value = v.Truncate(time.Microsecond)
+		case []byte:
+			p.Value = append([]byte(nil), v...)
+		case datastore.ByteString:
+			p.Value = append(datastore.ByteString(nil), v...)
+		default:
+			return nil, fmt.Errorf("datastore: invalid Value type for a Property with Name %q", p.Name)
+		}
+		out[i] = p
+	}
+
+	return out, nil
+}
+
+func itemValues(item storeItem, name string) []interface{} {
+	if name == "__key__" {
+		return []interface{}{item.key}
+	}
+	var values []interface{}
+	for _, p := range item.props {
+		if p.Name == name {
+			values = append(values, p.Value)
+		}
+	}
+	return values
+}
+
+func filterItems(items []storeItem, filters []EntityQueryFilter) ([]storeItem, error) {
+	var out []storeItem
+	for _, item := range items {
+		var matches = true
+		for _, filter := range filters {
+			if len(filter.Name) == 0 || len(filter.Operator) == 0 {
+				continue
+			}
+			ok, err := filterMatches(itemValues(item, filter.Name), filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+func filterMatches(values []interface{}, filter EntityQueryFilter) (bool, error) {
+	if filter.Operator == "in" {
+		list, ok := filter.Value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf(ErrFieldValueTypeNotValid, filter.Name)
+		}
+		for _, v := range list {
+			if matches, _ := filterMatches(values, EntityQueryFilter{Name: filter.Name, Operator: "=", Value: v}); matches {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	for _, v := range values {
+		c, ok := compareValues(v, filter.Value)
+		if !ok {
+			continue
+		}
+		var matches bool
+		switch filter.Operator {
+		case "=":
+			matches = c == 0
+		case "!=":
+			matches = c != 0
+		case "<":
+			matches = c < 0
+		case "<=":
+			matches = c <= 0
+		case ">":
+			matches = c > 0
+		case ">=":
+			matches = c >= 0
+		default:
+			return false, errMemoryOperator
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sortItems orders items like Datastore: entities without an order property are left out, multi-valued
+// properties sort by their smallest (ascending) or largest (descending) value and ties are broken by key
+func sortItems(items []storeItem, orders []string) []storeItem {
+	type sortable struct {
+		item storeItem
+		keys []interface{}
+	}
+
+	var rows []sortable
+	for _, item := range items {
+		var row = sortable{item: item}
+		var ok = true
+		for _, order := range orders {
+			desc := strings.HasPrefix(order, "-")
+			values := itemValues(item, strings.TrimPrefix(order, "-"))
+			if len(values) == 0 {
+				ok = false
+				break
+			}
+			var pick = values[0]
+			for _, v := range values[1:] {
+				if c, _ := compareValues(v, pick); (c < 0 && !desc) || (c > 0 && desc) {
+					pick = v
+				}
+			}
+			row.keys = append(row.keys, pick)
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for n, order := range orders {
+			c, _ := compareValues(rows[i].keys[n], rows[j].keys[n])
+			if c != 0 {
+				if strings.HasPrefix(order, "-") {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return compareKeys(rows[i].item.key, rows[j].item.key) < 0
+	})
+
+	var out = make([]storeItem, len(rows))
+	for i, row := range rows {
+		out[i] = row.item
+	}
+	return out
+}
+
+func projectItems(items []storeItem, names []string) []storeItem {
+	var out []storeItem
+	for _, item := range items {
+		var props []datastore.Property
+		var found = map[string]bool{}
+		for _, p := range item.props {
+			for _, name := range names {
+				if p.Name == name {
+					props = append(props, p)
+					found[name] = true
+				}
+			}
+		}
+		if len(found) == len(names) {
+			out = append(out, storeItem{key: item.key, props: props})
+		}
+	}
+	return out
+}
+
+func pageItems(items []storeItem, offset int, limit int) []storeItem {
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// valueRank groups values that can be compared with each other and orders the groups
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case time.Time:
+		return 2
+	case bool:
+		return 3
+	case []byte, datastore.ByteString:
+		return 4
+	case string, appengine.BlobKey:
+		return 5
+	case appengine.GeoPoint:
+		return 6
+	case *datastore.Key:
+		return 7
+	}
+	return 8
+}
+
+// compareValues returns -1, 0 or 1 and false if the values are of incomparable types
+func compareValues(a, b interface{}) (int, bool) {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1, false
+		}
+		return 1, false
+	}
+
+	switch av := a.(type) {
+	case nil:
+		return 0, true
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return compareInt64(av, bv), true
+		}
+		return compareFloat64(float64(av), b.(float64)), true
+	case float64:
+		if bv, ok := b.(int64); ok {
+			return compareFloat64(av, float64(bv)), true
+		}
+		return compareFloat64(av, b.(float64)), true
+	case time.Time:
+		bv := b.(time.Time)
+		if av.Before(bv) {
+			return -1, true
+		} else if av.After(bv) {
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		bv := b.(bool)
+		if av == bv {
+			return 0, true
+		} else if !av {
+			return -1, true
+		}
+		return 1, true
+	case []byte, datastore.ByteString:
+		return bytes.Compare(toBytes(a), toBytes(b)), true
+	case string, appengine.BlobKey:
+		return strings.Compare(toString(a), toString(b)), true
+	case appengine.GeoPoint:
+		bv := b.(appengine.GeoPoint)
+		if c := compareFloat64(av.Lat, bv.Lat); c != 0 {
+			return c, true
+		}
+		return compareFloat64(av.Lng, bv.Lng), true
+	case *datastore.Key:
+		return compareKeys(av, b.(*datastore.Key)), true
+	}
+	return 0, false
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func toBytes(v interface{}) []byte {
+	if bs, ok := v.(datastore.ByteString); ok {
+		return []byte(bs)
+	}
+	return v.([]byte)
+}
+
+func toString(v interface{}) string {
+	if bk, ok := v.(appengine.BlobKey); ok {
+		return string(bk)
+	}
+	return v.(string)
+}
+
+// compareKeys orders keys by path from the root; numeric IDs sort before string IDs
+func compareKeys(a, b *datastore.Key) int {
+	if a == nil || b == nil {
+		if a == b {
+			return 0
+		} else if a == nil {
+			return -1
+		}
+		return 1
+	}
+
+	pa, pb := keyPath(a), keyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if c := strings.Compare(pa[i].Kind(), pb[i].Kind()); c != 0 {
+			return c
+		}
+		ia, ib := pa[i].StringID() == "", pb[i].StringID() == ""
+		if ia != ib {
+			if ia {
+				return -1
+			}
+			return 1
+		}
+		if ia {
+			if c := compareInt64(pa[i].IntID(), pb[i].IntID()); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(pa[i].StringID(), pb[i].StringID()); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(pa)), int64(len(pb)))
+}
+
+func keyPath(k *datastore.Key) []*datastore.Key {
+	var path []*datastore.Key
+	for ; k != nil; k = k.Parent() {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
@@ -0,0 +1,1080 @@
+package sdk
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// SQLStore persists entities in a relational database through database/sql. Every entity gets a table with a
+// column per field, Multiple fields get a child table with one row per value and fields without NoIndex are
+// indexed. Next to each value column a type column keeps the Go type so values load back exactly as saved.
+// Fields without a type can hold values of any type; their numbers are also kept in a number column, so
+// range filters and sorts compare them as numbers like Datastore does.
+//
+// The driver isn't imported by the SDK:
+//
+//	db, _ := sql.Open("sqlite3", "file:app.db")
+//	sdk.NewApp(sdk.AppOptions{Store: sdk.NewSQLStore(db, sdk.SQLite)})
+type SQLStore struct {
+	db      *sql.DB
+	dialect *SQLDialect
+	schema  sync.Map // entity name -> true when its tables exist
+}
+
+// SQLDialect describes the differences between databases
+type SQLDialect struct {
+	Name        string
+	Types       map[string]string // SQL column type by sqlKind
+	Placeholder func(n int) string
+	NoLimit     string // LIMIT value used when only OFFSET is set
+	TxOptions   *sql.TxOptions
+	Retry       func(err error) bool // if true, a failed transaction is run again
+}
+
+const (
+	sqlAny   = "any"
+	sqlInt   = "int"
+	sqlFloat = "float"
+	sqlBool  = "bool"
+	sqlText  = "text"
+	sqlTime  = "time"
+	sqlBytes = "bytes"
+)
+
+var SQLite = &SQLDialect{
+	Name: "sqlite",
+	Types: map[string]string{
+		sqlAny:   "",
+		sqlInt:   "INTEGER",
+		sqlFloat: "REAL",
+		sqlBool:  "BOOLEAN",
+		sqlText:  "TEXT",
+		sqlTime:  "TIMESTAMP",
+		sqlBytes: "BLOB",
+	},
+	Placeholder: func(n int) string {
+		return "?"
+	},
+	NoLimit: "-1",
+}
+
+var PostgreSQL = &SQLDialect{
+	Name: "postgres",
+	Types: map[string]string{
+		sqlAny:   "TEXT",
+		sqlInt:   "BIGINT",
+		sqlFloat: "DOUBLE PRECISION",
+		sqlBool:  "BOOLEAN",
+		sqlText:  "TEXT",
+		sqlTime:  "TIMESTAMPTZ",
+		sqlBytes: "BYTEA",
+	},
+	Placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	NoLimit:   "ALL",
+	TxOptions: &sql.TxOptions{Isolation: sql.LevelSerializable},
+	Retry: func(err error) bool {
+		return strings.Contains(err.Error(), "40001") || strings.Contains(err.Error(), "could not serialize")
+	},
+}
+
+// type names stored in type columns
+const (
+	sqlTypeNull       = "null"
+	sqlTypeInt        = "int"
+	sqlTypeFloat      = "float"
+	sqlTypeBool       = "bool"
+	sqlTypeString     = "string"
+	sqlTypeBytes      = "bytes"
+	sqlTypeByteString = "bytestring"
+	sqlTypeTime       = "time"
+	sqlTypeKey        = "key"
+	sqlTypeGeoPoint   = "geo"
+	sqlTypeBlobKey    = "blobkey"
+)
+
+const (
+	sqlKeyColumn      = "__key__"
+	sqlIndexColumn    = "__index"
+	sqlValueColumn    = "value"
+	sqlTypeSuffix     = "__type"
+	sqlNumberSuffix   = "__num"
+	sqlSequenceTable  = "__sequence"
+	sqlChildSeparator = "__"
+)
+
+const (
+	ErrSQLKindNotRegistered string = "sql store: kind '%s' is not a registered entity"
+	ErrSQLPropertyNotField  string = "sql store: property '%s' is not a field of '%s'"
+)
+
+var errSQLOperator = errors.New("sql store: unsupported filter operator")
+
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func NewSQLStore(db *sql.DB, dialect *SQLDialect) *SQLStore {
+	return &SQLStore{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// Migrate creates or extends the tables of all registered entities. Tables are otherwise created on first use.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	for _, e := range Entities {
+		if err := s.migrate(ctx, e); err != nil {
+			return err
+		}
+		s.schema.Store(e.Name, true)
+	}
+	return nil
+}
+
+func (s *SQLStore) conn(ctx context.Context) sqlConn {
+	if tx, ok := ctx.Value(s).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+func (s *SQLStore) entity(ctx context.Context, kind string) (*Entity, error) {
+	e, ok := Entities[kind]
+	if !ok {
+		return nil, fmt.Errorf(ErrSQLKindNotRegistered, kind)
+	}
+	if _, ok := s.schema.Load(kind); !ok {
+		// inside a transaction tables are created on its connection; they are known to exist once it commits
+		if err := s.migrate(ctx, e); err != nil {
+			return nil, err
+		}
+		if _, ok := ctx.Value(s).(*sql.Tx); !ok {
+			s.schema.Store(kind, true)
+		}
+	}
+	return e, nil
+}
+
+func (s *SQLStore) migrate(ctx context.Context, e *Entity) error {
+	conn := s.conn(ctx)
+	singles, multiples := sqlFields(e)
+
+	var columns = []string{quoteIdent(sqlKeyColumn) + " TEXT PRIMARY KEY"}
+	for _, field := range singles {
+		columns = append(columns, s.columnDefinitions(field.datastoreFieldName, field)...)
+	}
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteIdent(e.Name)+" ("+strings.Join(columns, ", ")+")")
+	if err != nil {
+		return err
+	}
+
+	// add columns of fields defined after the table was created
+	existing, err := s.columns(ctx, e.Name)
+	if err != nil {
+		return err
+	}
+	for _, field := range singles {
+		if err := s.addColumns(ctx, e.Name, field.datastoreFieldName, field, existing); err != nil {
+			return err
+		}
+		if !field.NoIndex {
+			if err := s.createIndexes(ctx, e.Name, field.datastoreFieldName, e.Name+sqlChildSeparator+field.datastoreFieldName, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, field := range multiples {
+		table := sqlChildTable(e, field)
+		_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteIdent(table)+" ("+
+			quoteIdent(sqlKeyColumn)+" TEXT NOT NULL, "+
+			quoteIdent(sqlIndexColumn)+" INTEGER NOT NULL, "+
+			strings.Join(s.columnDefinitions(sqlValueColumn, field), ", ")+", "+
+			"PRIMARY KEY ("+quoteIdent(sqlKeyColumn)+", "+quoteIdent(sqlIndexColumn)+"))")
+		if err != nil {
+			return err
+		}
+		existing, err := s.columns(ctx, table)
+		if err != nil {
+			return err
+		}
+		if err := s.addColumns(ctx, table, sqlValueColumn, field, existing); err != nil {
+			return err
+		}
+		if !field.NoIndex {
+			if err := s.createIndexes(ctx, table, sqlValueColumn, table, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteIdent(sqlSequenceTable)+
+		" (\"kind\" TEXT PRIMARY KEY, \"last\" BIGINT NOT NULL)")
+	return err
+}
+
+// columnDefinitions returns definitions of value, type and number columns of field
+func (s *SQLStore) columnDefinitions(column string, field *Field) []string {
+	var definitions = []string{
+		strings.TrimSpace(quoteIdent(column) + " " + s.columnType(field)),
+		quoteIdent(column+sqlTypeSuffix) + " TEXT",
+	}
+	if sqlNumbers(field) {
+		definitions = append(definitions, quoteIdent(column+sqlNumberSuffix)+" "+s.dialect.Types[sqlFloat])
+	}
+	return definitions
+}
+
+// addColumns adds missing columns of field to table. Number columns added to existing tables are filled from
+// stored numbers.
+func (s *SQLStore) addColumns(ctx context.Context, table string, column string, field *Field, existing map[string]bool) error {
+	conn := s.conn(ctx)
+	var columns = map[string]string{
+		column:                 s.columnType(field),
+		column + sqlTypeSuffix: "TEXT",
+	}
+	if sqlNumbers(field) {
+		columns[column+sqlNumberSuffix] = s.dialect.Types[sqlFloat]
+	}
+	for name, definition := range columns {
+		if existing[name] {
+			continue
+		}
+		_, err := conn.ExecContext(ctx, "ALTER TABLE "+quoteIdent(table)+" ADD COLUMN "+strings.TrimSpace(quoteIdent(name)+" "+definition))
+		if err != nil {
+			return err
+		}
+		if name == column+sqlNumberSuffix && existing[column] {
+			st := &sqlStatement{dialect: s.dialect}
+			_, err = conn.ExecContext(ctx, "UPDATE "+quoteIdent(table)+" SET "+quoteIdent(name)+" = CAST("+quoteIdent(column)+" AS "+s.dialect.Types[sqlFloat]+
+				") WHERE "+quoteIdent(column+sqlTypeSuffix)+" IN ("+st.arg(sqlTypeInt)+", "+st.arg(sqlTypeFloat)+")", st.args...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) createIndexes(ctx context.Context, table string, column string, index string, field *Field) error {
+	var columns = map[string]string{column: index + "__idx"}
+	if sqlNumbers(field) {
+		columns[column+sqlNumberSuffix] = index + sqlNumberSuffix + "__idx"
+	}
+	for column, index := range columns {
+		_, err := s.conn(ctx).ExecContext(ctx, "CREATE INDEX IF NOT EXISTS "+quoteIdent(index)+" ON "+quoteIdent(table)+" ("+quoteIdent(column)+")")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) columns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT * FROM "+quoteIdent(table)+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var columns = map[string]bool{}
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+func (s *SQLStore) columnType(field *Field) string {
+	return s.dialect.Types[sqlColumnKind(field)]
+}
+
+// sqlColumnKind derives the column type from the field definition
+func sqlColumnKind(field *Field) string {
+	switch field.Type {
+	case FileType, ImageType, TextType, HTMLType, EnumType, SlugType:
+		return sqlText
+	case IntType:
+		return sqlInt
+	case FloatType:
+		return sqlFloat
+	case BoolType:
+		return sqlBool
+	case DateTimeType:
+		return sqlTime
+	}
+	switch field.DefaultValue.(type) {
+	case bool:
+		return sqlBool
+	case int64:
+		return sqlInt
+	case float64:
+		return sqlFloat
+	case string:
+		return sqlText
+	case time.Time:
+		return sqlTime
+	}
+	return sqlAny
+}
+
+// sqlNumbers returns true if numbers of field are also kept in a number column. Columns of fields without
+// a type hold values of any type; on most databases they are text columns that compare numbers as text.
+func sqlNumbers(field *Field) bool {
+	return sqlColumnKind(field) == sqlAny
+}
+
+// sqlFields returns entity fields split into single and multiple value fields in a stable order
+func sqlFields(e *Entity) ([]*Field, []*Field) {
+	var names []string
+	for name := range e.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var singles, multiples []*Field
+	for _, name := range names {
+		if field := e.fields[name]; field.Multiple {
+			multiples = append(multiples, field)
+		} else {
+			singles = append(singles, field)
+		}
+	}
+	return singles, multiples
+}
+
+func sqlChildTable(e *Entity, field *Field) string {
+	return e.Name + sqlChildSeparator + field.datastoreFieldName
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// sqlStatement collects arguments and hands out dialect placeholders
+type sqlStatement struct {
+	dialect *SQLDialect
+	args    []interface{}
+}
+
+func (st *sqlStatement) arg(v interface{}) string {
+	st.args = append(st.args, v)
+	return st.dialect.Placeholder(len(st.args))
+}
+
+func (s *SQLStore) Get(ctx context.Context, key *datastore.Key, dst datastore.PropertyLoadSaver) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	e, err := s.entity(ctx, key.Kind())
+	if err != nil {
+		return err
+	}
+
+	items, err := s.query(ctx, e, &StoreQuery{
+		Kind:    e.Name,
+		Filters: []EntityQueryFilter{{Name: "__key__", Operator: "=", Value: key}},
+	}, 0)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return datastore.ErrNoSuchEntity
+	}
+	return dst.Load(items[0].props)
+}
+
+func (s *SQLStore) GetMulti(ctx context.Context, keys []*datastore.Key, dst []datastore.PropertyLoadSaver) error {
+	if len(keys) != len(dst) {
+		return errors.New("sql store: key and dst slices have different length")
+	}
+
+	var multiErr = make(appengine.MultiError, len(keys))
+	var failed bool
+	for i, key := range keys {
+		if multiErr[i] = s.Get(ctx, key, dst[i]); multiErr[i] != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return multiErr
+	}
+	return nil
+}
+
+func (s *SQLStore) Put(ctx context.Context, key *datastore.Key, src datastore.PropertyLoadSaver) (*datastore.Key, error) {
+	if key == nil {
+		return nil, datastore.ErrInvalidKey
+	}
+	e, err := s.entity(ctx, key.Kind())
+	if err != nil {
+		return key, err
+	}
+
+	props, err := src.Save()
+	if err != nil {
+		return key, err
+	}
+
+	var values = map[*Field][]interface{}{}
+	for _, p := range props {
+		field, ok := e.fields[p.Name]
+		if !ok {
+			return key, fmt.Errorf(ErrSQLPropertyNotField, p.Name, e.Name)
+		}
+		values[field] = append(values[field], p.Value)
+	}
+
+	var putKey = key
+	err = s.RunInTransaction(ctx, func(tc context.Context) error {
+		putKey = key
+		if putKey.Incomplete() {
+			if putKey, err = s.allocateKey(tc, putKey); err != nil {
+				return err
+			}
+		}
+		if err := s.delete(tc, e, putKey); err != nil {
+			return err
+		}
+		return s.insert(tc, e, putKey, values)
+	})
+	return putKey, err
+}
+
+func (s *SQLStore) allocateKey(ctx context.Context, key *datastore.Key) (*datastore.Key, error) {
+	conn := s.conn(ctx)
+
+	st := &sqlStatement{dialect: s.dialect}
+	res, err := conn.ExecContext(ctx, "UPDATE "+quoteIdent(sqlSequenceTable)+` SET "last" = "last" + 1 WHERE "kind" = `+st.arg(key.Kind()), st.args...)
+	if err != nil {
+		return key, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return key, err
+	} else if n == 0 {
+		st = &sqlStatement{dialect: s.dialect}
+		_, err = conn.ExecContext(ctx, "INSERT INTO "+quoteIdent(sqlSequenceTable)+` ("kind", "last") VALUES (`+st.arg(key.Kind())+", 1)", st.args...)
+		if err != nil {
+			return key, err
+		}
+	}
+
+	var id int64
+	st = &sqlStatement{dialect: s.dialect}
+	err = conn.QueryRowContext(ctx, `SELECT "last" FROM `+quoteIdent(sqlSequenceTable)+` WHERE "kind" = `+st.arg(key.Kind()), st.args...).Scan(&id)
+	if err != nil {
+		return key, err
+	}
+
+	nctx, err := appengine.Namespace(ctx, key.Namespace())
+	if err != nil {
+		return key, err
+	}
+	return datastore.NewKey(nctx, key.Kind(), "", id, key.Parent()), nil
+}
+
+// sqlNumber returns value of number column of field
+func sqlNumber(field *Field, v interface{}) (float64, bool) {
+	if !sqlNumbers(field) {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func (s *SQLStore) insert(ctx context.Context, e *Entity, key *datastore.Key, values map[*Field][]interface{}) error {
+	conn := s.conn(ctx)
+	encoded := key.Encode()
+	singles, multiples := sqlFields(e)
+
+	st := &sqlStatement{dialect: s.dialect}
+	var columns = []string{quoteIdent(sqlKeyColumn)}
+	var placeholders = []string{st.arg(encoded)}
+	for _, field := range singles {
+		vs, ok := values[field]
+		if !ok {
+			continue
+		}
+		value, typ, err := toSQLValue(vs[0])
+		if err != nil {
+			return err
+		}
+		columns = append(columns, quoteIdent(field.datastoreFieldName), quoteIdent(field.datastoreFieldName+sqlTypeSuffix))
+		placeholders = append(placeholders, st.arg(value), st.arg(typ))
+		if number, ok := sqlNumber(field, vs[0]); ok {
+			columns = append(columns, quoteIdent(field.datastoreFieldName+sqlNumberSuffix))
+			placeholders = append(placeholders, st.arg(number))
+		}
+	}
+	_, err := conn.ExecContext(ctx, "INSERT INTO "+quoteIdent(e.Name)+" ("+strings.Join(columns, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")", st.args...)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range multiples {
+		for i, v := range values[field] {
+			value, typ, err := toSQLValue(v)
+			if err != nil {
+				return err
+			}
+			st := &sqlStatement{dialect: s.dialect}
+			var columns = []string{quoteIdent(sqlKeyColumn), quoteIdent(sqlIndexColumn), quoteIdent(sqlValueColumn), quoteIdent(sqlValueColumn + sqlTypeSuffix)}
+			var placeholders = []string{st.arg(encoded), st.arg(i), st.arg(value), st.arg(typ)}
+			if number, ok := sqlNumber(field, v); ok {
+				columns = append(columns, quoteIdent(sqlValueColumn+sqlNumberSuffix))
+				placeholders = append(placeholders, st.arg(number))
+			}
+			_, err = conn.ExecContext(ctx, "INSERT INTO "+quoteIdent(sqlChildTable(e, field))+" ("+strings.Join(columns, ", ")+
+				") VALUES ("+strings.Join(placeholders, ", ")+")", st.args...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// PutMulti puts entities in a transaction
+func (s *SQLStore) PutMulti(ctx context.Context, keys []*datastore.Key, src []datastore.PropertyLoadSaver) ([]*datastore.Key, error) {
+	if len(keys) != len(src) {
+		return nil, errors.New("sql store: key and src slices have different length")
+	}
+
+	var putKeys = make([]*datastore.Key, len(keys))
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		var multiErr = make(appengine.MultiError, len(keys))
+		var failed bool
+		for i, key := range keys {
+			if putKeys[i], multiErr[i] = s.Put(tc, key, src[i]); multiErr[i] != nil {
+				failed = true
+			}
+		}
+		if failed {
+			return multiErr
+		}
+		return nil
+	})
+	return putKeys, err
+}
+
+// DeleteMulti deletes entities in a transaction
+func (s *SQLStore) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		var multiErr = make(appengine.MultiError, len(keys))
+		var failed bool
+		for i, key := range keys {
+			if multiErr[i] = s.Delete(tc, key); multiErr[i] != nil {
+				failed = true
+			}
+		}
+		if failed {
+			return multiErr
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) Delete(ctx context.Context, key *datastore.Key) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	e, err := s.entity(ctx, key.Kind())
+	if err != nil {
+		return err
+	}
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		return s.delete(tc, e, key)
+	})
+}
+
+func (s *SQLStore) delete(ctx context.Context, e *Entity, key *datastore.Key) error {
+	conn := s.conn(ctx)
+	encoded := key.Encode()
+	_, multiples := sqlFields(e)
+
+	var tables = []string{e.Name}
+	for _, field := range multiples {
+		tables = append(tables, sqlChildTable(e, field))
+	}
+	for _, table := range tables {
+		st := &sqlStatement{dialect: s.dialect}
+		_, err := conn.ExecContext(ctx, "DELETE FROM "+quoteIdent(table)+" WHERE "+quoteIdent(sqlKeyColumn)+" = "+st.arg(encoded), st.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Run(ctx context.Context, q *StoreQuery) StoreIterator {
+	e, err := s.entity(ctx, q.Kind)
+	if err != nil {
+		return &sliceIterator{err: err}
+	}
+	start, err := decodeOffsetCursor(q.Cursor)
+	if err != nil {
+		return &sliceIterator{err: err}
+	}
+	start += q.Offset
+
+	items, err := s.query(ctx, e, q, start)
+	return &sliceIterator{items: items, err: err, offset: start}
+}
+
+func (s *SQLStore) query(ctx context.Context, e *Entity, q *StoreQuery, offset int) ([]storeItem, error) {
+	conn := s.conn(ctx)
+	singles, multiples := sqlFields(e)
+
+	if len(q.Project) > 0 {
+		var project = map[string]bool{}
+		for _, name := range q.Project {
+			project[name] = true
+		}
+		var ps, pm []*Field
+		for _, field := range singles {
+			if project[field.datastoreFieldName] {
+				ps = append(ps, field)
+			}
+		}
+		for _, field := range multiples {
+			if project[field.datastoreFieldName] {
+				pm = append(pm, field)
+			}
+		}
+		singles, multiples = ps, pm
+	}
+
+	st := &sqlStatement{dialect: s.dialect}
+	var columns = []string{"t." + quoteIdent(sqlKeyColumn)}
+	for _, field := range singles {
+		columns = append(columns, "t."+quoteIdent(field.datastoreFieldName), "t."+quoteIdent(field.datastoreFieldName+sqlTypeSuffix))
+	}
+
+	var where []string
+	for _, filter := range q.Filters {
+		if len(filter.Name) == 0 || len(filter.Operator) == 0 {
+			continue
+		}
+		cond, err := s.filterCondition(st, e, filter)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, cond)
+	}
+
+	var orderBy []string
+	for _, order := range q.Orders {
+		desc := strings.HasPrefix(order, "-")
+		name := strings.TrimPrefix(order, "-")
+		direction := " ASC"
+		if desc {
+			direction = " DESC"
+		}
+		if name == "__key__" {
+			orderBy = append(orderBy, "t."+quoteIdent(sqlKeyColumn)+direction)
+			continue
+		}
+		field, ok := e.fields[name]
+		if !ok {
+			return nil, fmt.Errorf(ErrNamedFieldNotDefined, name)
+		}
+		// numbers of fields without a type sort first and by their number column
+		if field.Multiple {
+			table := quoteIdent(sqlChildTable(e, field))
+			aggregate := "MIN"
+			if desc {
+				aggregate = "MAX"
+			}
+			where = append(where, "EXISTS (SELECT 1 FROM "+table+" c WHERE c."+quoteIdent(sqlKeyColumn)+" = t."+quoteIdent(sqlKeyColumn)+")")
+			var columns = []string{quoteIdent(sqlValueColumn)}
+			if sqlNumbers(field) {
+				columns = []string{quoteIdent(sqlValueColumn + sqlNumberSuffix), quoteIdent(sqlValueColumn)}
+				orderBy = append(orderBy, "(SELECT "+aggregate+"(c."+columns[0]+") FROM "+table+" c WHERE c."+
+					quoteIdent(sqlKeyColumn)+" = t."+quoteIdent(sqlKeyColumn)+") IS NULL"+direction)
+			}
+			for _, column := range columns {
+				orderBy = append(orderBy, "(SELECT "+aggregate+"(c."+column+") FROM "+table+" c WHERE c."+
+					quoteIdent(sqlKeyColumn)+" = t."+quoteIdent(sqlKeyColumn)+")"+direction)
+			}
+		} else {
+			where = append(where, "t."+quoteIdent(field.datastoreFieldName+sqlTypeSuffix)+" IS NOT NULL")
+			if sqlNumbers(field) {
+				number := "t." + quoteIdent(field.datastoreFieldName+sqlNumberSuffix)
+				orderBy = append(orderBy, "("+number+" IS NULL)"+direction, number+direction)
+			}
+			orderBy = append(orderBy, "t."+quoteIdent(field.datastoreFieldName)+direction)
+		}
+	}
+	orderBy = append(orderBy, "t."+quoteIdent(sqlKeyColumn))
+
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM " + quoteIdent(e.Name) + " t"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + strings.Join(orderBy, ", ")
+	if q.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	} else if offset > 0 {
+		query += " LIMIT " + s.dialect.NoLimit
+	}
+	if offset > 0 {
+		query += " OFFSET " + strconv.Itoa(offset)
+	}
+
+	rows, err := conn.QueryContext(ctx, query, st.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []storeItem
+	for rows.Next() {
+		var encoded string
+		var raw = make([]interface{}, len(singles)*2)
+		var dest = []interface{}{&encoded}
+		for i := range raw {
+			dest = append(dest, &raw[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		key, err := datastore.DecodeKey(encoded)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		var item = storeItem{key: key}
+		for i, field := range singles {
+			if raw[i*2+1] == nil {
+				continue
+			}
+			value, err := fromSQLValue(raw[i*2], raw[i*2+1])
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			item.props = append(item.props, datastore.Property{
+				Name:    field.datastoreFieldName,
+				Value:   value,
+				NoIndex: field.NoIndex,
+			})
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// values of Multiple fields are loaded once the main query is closed
+	for i := range items {
+		for _, field := range multiples {
+			values, err := s.childValues(ctx, e, field, items[i].key)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				items[i].props = append(items[i].props, datastore.Property{
+					Name:     field.datastoreFieldName,
+					Value:    value,
+					NoIndex:  field.NoIndex,
+					Multiple: true,
+				})
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func (s *SQLStore) childValues(ctx context.Context, e *Entity, field *Field, key *datastore.Key) ([]interface{}, error) {
+	st := &sqlStatement{dialect: s.dialect}
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+quoteIdent(sqlValueColumn)+", "+quoteIdent(sqlValueColumn+sqlTypeSuffix)+
+		" FROM "+quoteIdent(sqlChildTable(e, field))+" WHERE "+quoteIdent(sqlKeyColumn)+" = "+st.arg(key.Encode())+
+		" ORDER BY "+quoteIdent(sqlIndexColumn), st.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []interface{}
+	for rows.Next() {
+		var raw, typ interface{}
+		if err := rows.Scan(&raw, &typ); err != nil {
+			return nil, err
+		}
+		value, err := fromSQLValue(raw, typ)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// filterCondition translates a filter to a condition over table alias t. Like in Datastore, values only
+// match values of the same type.
+func (s *SQLStore) filterCondition(st *sqlStatement, e *Entity, filter EntityQueryFilter) (string, error) {
+	var operator = filter.Operator
+	switch operator {
+	case "=", "<", "<=", ">", ">=", "in":
+	case "!=":
+		operator = "<>"
+	default:
+		return "", errSQLOperator
+	}
+
+	if filter.Name == "__key__" {
+		key, ok := filter.Value.(*datastore.Key)
+		if !ok {
+			return "", fmt.Errorf(ErrFieldValueTypeNotValid, filter.Name)
+		}
+		if operator == "in" {
+			return "", errSQLOperator
+		}
+		return "t." + quoteIdent(sqlKeyColumn) + " " + operator + " " + st.arg(key.Encode()), nil
+	}
+
+	field, ok := e.fields[filter.Name]
+	if !ok {
+		return "", fmt.Errorf(ErrNamedFieldNotDefined, filter.Name)
+	}
+
+	column, typeColumn := "t."+quoteIdent(field.datastoreFieldName), "t."+quoteIdent(field.datastoreFieldName+sqlTypeSuffix)
+	numberColumn := "t." + quoteIdent(field.datastoreFieldName+sqlNumberSuffix)
+	if field.Multiple {
+		column, typeColumn = "c."+quoteIdent(sqlValueColumn), "c."+quoteIdent(sqlValueColumn+sqlTypeSuffix)
+		numberColumn = "c." + quoteIdent(sqlValueColumn+sqlNumberSuffix)
+	}
+	if !sqlNumbers(field) {
+		numberColumn = ""
+	}
+
+	var cond string
+	if operator == "in" {
+		list, ok := filter.Value.([]interface{})
+		if !ok {
+			return "", fmt.Errorf(ErrFieldValueTypeNotValid, filter.Name)
+		}
+		var conds = []string{"1 = 0"}
+		for _, v := range list {
+			c, err := sqlCompare(st, column, typeColumn, numberColumn, "=", v)
+			if err != nil {
+				return "", err
+			}
+			conds = append(conds, c)
+		}
+		cond = "(" + strings.Join(conds, " OR ") + ")"
+	} else {
+		var err error
+		if cond, err = sqlCompare(st, column, typeColumn, numberColumn, operator, filter.Value); err != nil {
+			return "", err
+		}
+	}
+
+	if field.Multiple {
+		cond = "EXISTS (SELECT 1 FROM " + quoteIdent(sqlChildTable(e, field)) + " c WHERE c." + quoteIdent(sqlKeyColumn) + " = t." + quoteIdent(sqlKeyColumn) + " AND " + cond + ")"
+	}
+	return cond, nil
+}
+
+// sqlCompare compares column with v; numbers are compared with numberColumn if it is set
+func sqlCompare(st *sqlStatement, column string, typeColumn string, numberColumn string, operator string, v interface{}) (string, error) {
+	value, typ, err := toSQLValue(v)
+	if err != nil {
+		return "", err
+	}
+
+	switch typ {
+	case sqlTypeNull:
+		if operator != "=" {
+			return "", errSQLOperator
+		}
+		return typeColumn + " = " + st.arg(sqlTypeNull), nil
+	case sqlTypeInt, sqlTypeFloat:
+		if len(numberColumn) != 0 {
+			number, _ := v.(float64)
+			if i, ok := v.(int64); ok {
+				number = float64(i)
+			}
+			return numberColumn + " " + operator + " " + st.arg(number), nil
+		}
+		return "(" + column + " " + operator + " " + st.arg(value) + " AND " + typeColumn + " IN (" + st.arg(sqlTypeInt) + ", " + st.arg(sqlTypeFloat) + "))", nil
+	}
+	return "(" + column + " " + operator + " " + st.arg(value) + " AND " + typeColumn + " = " + st.arg(typ) + ")", nil
+}
+
+func (s *SQLStore) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	// operations of a nested transaction become part of the running one
+	if _, ok := ctx.Value(s).(*sql.Tx); ok {
+		return f(ctx)
+	}
+
+	var err error
+	for i := 0; i < 3; i++ {
+		var tx *sql.Tx
+		tx, err = s.db.BeginTx(ctx, s.dialect.TxOptions)
+		if err != nil {
+			return err
+		}
+		if err = f(context.WithValue(ctx, s, tx)); err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err == nil || s.dialect.Retry == nil || !s.dialect.Retry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// toSQLValue converts a property value to a database value and the name of its Go type
+func toSQLValue(v interface{}) (interface{}, string, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, sqlTypeNull, nil
+	case int64:
+		return val, sqlTypeInt, nil
+	case float64:
+		return val, sqlTypeFloat, nil
+	case bool:
+		return val, sqlTypeBool, nil
+	case string:
+		return val, sqlTypeString, nil
+	case []byte:
+		return val, sqlTypeBytes, nil
+	case datastore.ByteString:
+		return []byte(val), sqlTypeByteString, nil
+	case time.Time:
+		return val.UTC(), sqlTypeTime, nil
+	case *datastore.Key:
+		return val.Encode(), sqlTypeKey, nil
+	case appengine.GeoPoint:
+		return strconv.FormatFloat(val.Lat, 'g', -1, 64) + "," + strconv.FormatFloat(val.Lng, 'g', -1, 64), sqlTypeGeoPoint, nil
+	case appengine.BlobKey:
+		return string(val), sqlTypeBlobKey, nil
+	}
+	return nil, "", fmt.Errorf("sql store: invalid value type %T", v)
+}
+
+var sqlTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// fromSQLValue converts a scanned database value back to the Go type it was saved with
+func fromSQLValue(raw interface{}, typ interface{}) (interface{}, error) {
+	var t string
+	switch v := typ.(type) {
+	case string:
+		t = v
+	case []byte:
+		t = string(v)
+	}
+
+	if t == sqlTypeNull || raw == nil {
+		return nil, nil
+	}
+
+	var str string
+	switch v := raw.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	}
+
+	switch t {
+	case sqlTypeInt:
+		switch v := raw.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		}
+		return strconv.ParseInt(str, 10, 64)
+	case sqlTypeFloat:
+		switch v := raw.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+		return strconv.ParseFloat(str, 64)
+	case sqlTypeBool:
+		switch v := raw.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+		return str == "t" || str == "1" || strings.EqualFold(str, "true"), nil
+	case sqlTypeString:
+		if _, ok := raw.([]byte); ok || len(str) > 0 {
+			return str, nil
+		}
+		return fmt.Sprint(raw), nil
+	case sqlTypeBytes:
+		return []byte(str), nil
+	case sqlTypeByteString:
+		return datastore.ByteString(str), nil
+	case sqlTypeBlobKey:
+		return appengine.BlobKey(str), nil
+	case sqlTypeTime:
+		if tm, ok := raw.(time.Time); ok {
+			return tm, nil
+		}
+		for _, layout := range sqlTimeLayouts {
+			if tm, err := time.Parse(layout, str); err == nil {
+				return tm, nil
+			}
+		}
+		return nil, fmt.Errorf("sql store: can't parse time '%s'", str)
+	case sqlTypeKey:
+		return datastore.DecodeKey(str)
+	case sqlTypeGeoPoint:
+		var gp appengine.GeoPoint
+		parts := strings.Split(str, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("sql store: can't parse geo point '%s'", str)
+		}
+		var err error
+		if gp.Lat, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return nil, err
+		}
+		if gp.Lng, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return nil, err
+		}
+		return gp, nil
+	}
+	return nil, fmt.Errorf("sql store: unknown value type '%s'", t)
+}
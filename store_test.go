@@ -0,0 +1,234 @@
+package sdk
+
+import (
+	"errors"
+	"log"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+var errRollback = errors.New("rollback")
+
+// keys need app id and log needs standard log outside App Engine
+func TestMain(m *testing.M) {
+	os.Setenv("GAE_APPLICATION", "s~test")
+	logDebugf = func(c context.Context, format string, args ...interface{}) {}
+	logInfof = testLogf
+	logErrorf = testLogf
+	os.Exit(m.Run())
+}
+
+func testLogf(c context.Context, format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// newTestEntity returns registered entity with name, number and tags fields
+func newTestEntity(t *testing.T, name string) *Entity {
+	e := &Entity{Name: name, Fields: []*Field{
+		{Name: "name", IsRequired: true},
+		{Name: "n", Type: IntType},
+		{Name: "tags", Multiple: true},
+	}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// useStore makes s the store of the app and returns context with all scopes. Change events are not dispatched.
+func useStore(s Store) Context {
+	store = s
+	dispatcher = nil
+	return Context{Context: context.Background()}.WithScopes(ScopeAdd, ScopeEdit, ScopeDelete, ScopeRead, ScopeWrite)
+}
+
+func addTestItem(t *testing.T, ctx Context, e *Entity, m map[string]interface{}) *datastore.Key {
+	h, err := e.FromMap(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key := e.NewIncompleteKey(ctx)
+	key, err = e.Add(ctx, key, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func queryNumbers(hs []*EntityDataHolder) []int64 {
+	var ns []int64
+	for _, h := range hs {
+		n, _ := h.data[h.Entity.fields["n"]].(int64)
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func equalNumbers(a []int64, b ...int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// testStore runs entity operations on s; kind prefixes entity names so every store has its own entities
+func testStore(t *testing.T, s Store, kind string) {
+	ctx := useStore(s)
+
+	t.Run("CRUD", func(t *testing.T) {
+		e := newTestEntity(t, kind+"CRUD")
+		key := addTestItem(t, ctx, e, map[string]interface{}{"name": "a", "n": int64(1), "tags": []interface{}{"x", "y"}})
+
+		h, err := e.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := h.Get(ctx, "name"); name != "a" {
+			t.Fatalf("name is %v, want a", name)
+		}
+		if tags, _ := h.data[e.fields["tags"]].([]interface{}); len(tags) != 2 {
+			t.Fatalf("tags are %v, want 2 tags", tags)
+		}
+
+		edit, err := e.FromMap(ctx, map[string]interface{}{"name": "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = e.Edit(ctx, key, edit); err != nil {
+			t.Fatal(err)
+		}
+		h, err = e.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := h.Get(ctx, "name"); name != "b" {
+			t.Fatalf("name is %v after edit, want b", name)
+		}
+		if n, _ := h.data[e.fields["n"]].(int64); n != 1 {
+			t.Fatalf("n is %v after edit, want 1", n)
+		}
+		if h.Version() != 2 {
+			t.Fatalf("version is %d after edit, want 2", h.Version())
+		}
+
+		if err = e.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = e.Get(ctx, key); err != datastore.ErrNoSuchEntity {
+			t.Fatalf("get after delete returned %v, want ErrNoSuchEntity", err)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		e := newTestEntity(t, kind+"Query")
+		for i, tag := range []string{"a", "b", "c", "d", "e"} {
+			addTestItem(t, ctx, e, map[string]interface{}{"name": tag, "n": int64(i), "tags": []interface{}{"all", tag}})
+		}
+
+		hs, _, err := e.Query(ctx, "-n", 2, 0, "", EntityQueryFilter{Name: "n", Operator: ">=", Value: int64(1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns := queryNumbers(hs); !equalNumbers(ns, 4, 3) {
+			t.Fatalf("query returned %v, want [4 3]", ns)
+		}
+
+		hs, _, err = e.Query(ctx, "n", 0, 1, "", EntityQueryFilter{Name: "n", Operator: "<", Value: int64(3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns := queryNumbers(hs); !equalNumbers(ns, 1, 2) {
+			t.Fatalf("query with offset returned %v, want [1 2]", ns)
+		}
+
+		hs, _, err = e.Query(ctx, "", 0, 0, "", EntityQueryFilter{Name: "tags", Operator: "=", Value: "c"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns := queryNumbers(hs); !equalNumbers(ns, 2) {
+			t.Fatalf("query of multiple field returned %v, want [2]", ns)
+		}
+
+		hs, _, err = e.Query(ctx, "n", 0, 0, "", EntityQueryFilter{Name: "name", Operator: "in", Value: []interface{}{"b", "e"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns := queryNumbers(hs); !equalNumbers(ns, 1, 4) {
+			t.Fatalf("in query returned %v, want [1 4]", ns)
+		}
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		e := newTestEntity(t, kind+"Cursor")
+		for i := 0; i < 5; i++ {
+			addTestItem(t, ctx, e, map[string]interface{}{"name": "item", "n": int64(i)})
+		}
+
+		var pages [][]int64
+		var cursor string
+		for {
+			hs, next, err := e.Query(ctx, "n", 2, 0, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, queryNumbers(hs))
+			if len(next) == 0 {
+				break
+			}
+			if len(pages) > 5 {
+				t.Fatal("cursor doesn't advance")
+			}
+			cursor = next
+		}
+		if len(pages) != 3 || !equalNumbers(pages[0], 0, 1) || !equalNumbers(pages[1], 2, 3) || !equalNumbers(pages[2], 4) {
+			t.Fatalf("pages are %v, want [[0 1] [2 3] [4]]", pages)
+		}
+
+		if _, _, err := e.Query(ctx, "n", 2, 0, "not a cursor"); err != ErrInvalidCursor {
+			t.Fatalf("query with invalid cursor returned %v, want ErrInvalidCursor", err)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		e := newTestEntity(t, kind+"Transaction")
+
+		var key *datastore.Key
+		err := store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+			key = addTestItem(t, Context{Context: tc}.WithScopes(ScopeAdd), e, map[string]interface{}{"name": "a"})
+			return errRollback
+		})
+		if err != errRollback {
+			t.Fatalf("transaction returned %v, want errRollback", err)
+		}
+		if _, err = e.Get(ctx, key); err != datastore.ErrNoSuchEntity {
+			t.Fatalf("get after rollback returned %v, want ErrNoSuchEntity", err)
+		}
+
+		err = store.RunInTransaction(ctx.Context, func(tc context.Context) error {
+			key = addTestItem(t, Context{Context: tc}.WithScopes(ScopeAdd), e, map[string]interface{}{"name": "b"})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := e.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := h.Get(ctx, "name"); name != "b" {
+			t.Fatalf("name is %v after commit, want b", name)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), "memory")
+}
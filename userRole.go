@@ -1,7 +1,5 @@
 package sdk
 
-import "google.golang.org/appengine/log"
-
 //type role map[Role]map[Scope]bool
 
 //var userRoles = map[string]role{}
@@ -16,7 +14,7 @@ const (
 )
 
 func (c Context) HasScope(e *Entity, scope Scope) bool {
-	log.Debugf(c.Context, "HasScope: Entity %s, Scope %v", e.Name, scope)
+	logDebugf(c.Context, "HasScope: Entity %s, Scope %v", e.Name, scope)
 
 	if c.scopes != nil {
 		if s, ok := c.scopes[scope]; ok {
@@ -25,7 +23,7 @@ func (c Context) HasScope(e *Entity, scope Scope) bool {
 	}
 
 	if role, ok := e.Rules[c.Role]; ok {
-		log.Debugf(c.Context, "HasScope: Role %s, Rule: %v", c.Role, role)
+		logDebugf(c.Context, "HasScope: Role %s, Rule: %v", c.Role, role)
 		if s, ok := role[scope]; ok {
 			return s
 		}
@@ -2,6 +2,8 @@ package sdk
 
 import (
 	"archive/zip"
+	crand "crypto/rand"
+	"encoding/base64"
 	"io"
 	"math/rand"
 	"os"
@@ -55,6 +57,16 @@ func RandNumberBytesMaskImprSrc(n int) string {
 	return string(b)
 }
 
+// randomToken returns URL-safe secret of n random bytes; use it instead of the math/rand functions above for
+// anything that must not be guessed
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func Unzip(src, dest string) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
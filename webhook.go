@@ -0,0 +1,404 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// webhook operations
+const (
+	WebhookAdd    = "add"
+	WebhookEdit   = "edit"
+	WebhookDelete = "delete"
+)
+
+const ErrWebhookEntityNotValid string = "entity '%s' doesn't send webhooks"
+
+// webhookTimeout limits one delivery request
+const webhookTimeout = 10 * time.Second
+
+// webhook request headers; signature is hex encoded HMAC-SHA256 of timestamp, a dot and the body
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+)
+
+var errWebhookStatus = errors.New("webhook responded with error status")
+
+var webhookEntity = &Entity{
+	Name: "_webhook",
+	Fields: []*Field{
+		{
+			Name:       "entity",
+			IsRequired: true,
+		},
+		{
+			Name:       "ops",
+			Type:       EnumType,
+			Enum:       []string{WebhookAdd, WebhookEdit, WebhookDelete},
+			Multiple:   true,
+			IsRequired: true,
+		},
+		{
+			Name:       "url",
+			NoIndex:    true,
+			IsRequired: true,
+			Validator: func(value interface{}) bool {
+				s, ok := value.(string)
+				return ok && govalidator.IsRequestURL(s)
+			},
+		},
+		{
+			Name:    "secret",
+			NoIndex: true,
+			Json:    NoJsonOutput,
+		},
+	},
+}
+
+// webhookDeliveryEntity logs every delivery attempt
+var webhookDeliveryEntity = &Entity{
+	Name: "_webhookDelivery",
+	Fields: []*Field{
+		{Name: "webhook", Type: KeyType, Entity: webhookEntity.Name},
+		{Name: "event"},
+		{Name: "entity"},
+		{Name: "record", Type: KeyType},
+		{Name: "op"},
+		{Name: "status", Type: IntType},
+		{Name: "success", Type: BoolType},
+		{Name: "error", Type: TextType},
+	},
+}
+
+// webhookSender delivers change events of entities with Webhooks enabled
+var webhookSender = &Subscriber{
+	Name: "webhooks",
+	Match: func(e *Entity) bool {
+		return e.Webhooks
+	},
+	Handle: sendWebhooks,
+}
+
+// EnableWebhooks adds admin API for webhook subscriptions. Entities send webhooks if Webhooks is enabled.
+func (a *SDK) EnableWebhooks() {
+	if _, err := webhookEntity.init(); err != nil {
+		panic(err)
+	}
+	if _, err := webhookDeliveryEntity.init(); err != nil {
+		panic(err)
+	}
+	webhookEntity.SetRule(AdminRole, ScopeOwn)
+	webhookDeliveryEntity.SetRule(AdminRole, ScopeRead)
+	Subscribe(webhookSender)
+
+	a.describe(a.HandleFunc("/webhooks", handleWebhooks).Methods(http.MethodGet),
+		&apiOperation{summary: "Webhook subscriptions", tag: "webhooks", entity: webhookEntity, output: outputList, query: []string{"limit", "cursor"}})
+	a.describe(a.HandleFunc("/webhooks", handleAddWebhook).Methods(http.MethodPost),
+		&apiOperation{summary: "Subscribe to entity changes; response holds the signing secret", tag: "webhooks", entity: webhookEntity, input: true})
+	a.describe(a.HandleFunc("/webhooks/{encodedKey}", handleDeleteWebhook).Methods(http.MethodDelete),
+		&apiOperation{summary: "Delete webhook subscription", tag: "webhooks"})
+	a.describe(a.HandleFunc("/webhooks/{encodedKey}/deliveries", handleWebhookDeliveries).Methods(http.MethodGet),
+		&apiOperation{summary: "Delivery attempts of webhook, last first", tag: "webhooks", entity: webhookDeliveryEntity, output: outputList, query: []string{"limit", "cursor"}})
+}
+
+// webhookOp maps revision action of event to webhook operation
+func webhookOp(ev *ChangeEvent) string {
+	switch ev.Op {
+	case RevisionAdd:
+		return WebhookAdd
+	case RevisionPut:
+		if ev.Before == nil {
+			return WebhookAdd
+		}
+	case RevisionDelete, RevisionPurge:
+		return WebhookDelete
+	}
+	return WebhookEdit
+}
+
+// sendWebhooks sends event to subscriptions of its entity and operation. Subscriptions that already received
+// the event are skipped, so failed ones are retried with the event.
+func sendWebhooks(c context.Context, ev *ChangeEvent) error {
+	op := webhookOp(ev)
+
+	var hooks []*EntityDataHolder
+	var keys []*datastore.Key
+	t := store.Run(c, &StoreQuery{
+		Kind:    webhookEntity.Name,
+		Filters: []EntityQueryFilter{{Name: "entity", Operator: "=", Value: ev.Entity}},
+	})
+	for {
+		var h = &EntityDataHolder{Entity: webhookEntity, data: Data{}}
+		k, err := t.Next(h)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, v := range fieldValues(webhookEntity.fields["ops"], h.data[webhookEntity.fields["ops"]]) {
+			if v == op {
+				hooks = append(hooks, h)
+				keys = append(keys, k)
+				break
+			}
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	body, err := webhookPayload(c, ev, op)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i, h := range hooks {
+		delivered, err := webhookDelivered(c, keys[i], ev.Id)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			continue
+		}
+		if err := sendWebhook(c, keys[i], h, ev, op, body); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// webhookPayload outputs the stored entity as it is output by API; deleted entities are output as they were
+// before the delete
+func webhookPayload(c context.Context, ev *ChangeEvent, op string) ([]byte, error) {
+	var data = ev.After
+	if op == WebhookDelete {
+		data = ev.Before
+	} else if e, ok := Entities[ev.Entity]; ok {
+		h, err := e.stored(c, ev.Key)
+		if err == nil {
+			h.Id = ev.Key.Encode()
+			data = h.Output(Context{Context: c, Role: AdminRole, body: &Body{}})
+		} else if err != datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":     ev.Id,
+		"entity": ev.Entity,
+		"key":    ev.Key.Encode(),
+		"op":     op,
+		"time":   ev.Time,
+		"data":   data,
+	})
+}
+
+func webhookDelivered(c context.Context, key *datastore.Key, eventId string) (bool, error) {
+	t := store.Run(c, &StoreQuery{
+		Kind: webhookDeliveryEntity.Name,
+		Filters: []EntityQueryFilter{
+			{Name: "webhook", Operator: "=", Value: key},
+			{Name: "event", Operator: "=", Value: eventId},
+			{Name: "success", Operator: "=", Value: true},
+		},
+		Limit: 1,
+	})
+	_, err := t.Next(&EntityDataHolder{Entity: webhookDeliveryEntity, data: Data{}})
+	if err == datastore.Done {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// signWebhook returns hex encoded HMAC-SHA256 of timestamp and body
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook posts signed body to subscription and logs the attempt
+func sendWebhook(c context.Context, key *datastore.Key, h *EntityDataHolder, ev *ChangeEvent, op string, body []byte) error {
+	var f = webhookEntity.fields
+	url, _ := h.data[f["url"]].(string)
+	secret, _ := h.data[f["secret"]].(string)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var status int
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookEventHeader, ev.Id)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(secret, timestamp, body))
+
+		tc, cancel := context.WithTimeout(c, webhookTimeout)
+		defer cancel()
+		resp, err := urlfetch.Client(tc).Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status < 200 || status >= 300 {
+			return errWebhookStatus
+		}
+		return nil
+	}()
+
+	var d = webhookDeliveryEntity.fields
+	var delivery = &EntityDataHolder{Entity: webhookDeliveryEntity, data: Data{
+		d["webhook"]:    key,
+		d["event"]:      ev.Id,
+		d["entity"]:     ev.Entity,
+		d["record"]:     ev.Key,
+		d["op"]:         op,
+		d["status"]:     int64(status),
+		d["success"]:    err == nil,
+		d["_createdAt"]: time.Now(),
+	}}
+	if err != nil {
+		delivery.data[d["error"]] = err.Error()
+	}
+	if _, perr := store.Put(c, datastore.NewIncompleteKey(c, webhookDeliveryEntity.Name, nil), delivery); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	hs, nextCursor, err := webhookEntity.Query(ctx, "", limit, 0, q.Get("cursor"))
+	if err == ErrNotAuthorized {
+		ctx.PrintError(w, err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var data []map[string]interface{}
+	for _, h := range hs {
+		data = append(data, h.Output(ctx))
+	}
+	ctx.Print(w, map[string]interface{}{
+		"data":       data,
+		"count":      len(data),
+		"nextCursor": nextCursor,
+	})
+}
+
+// handleAddWebhook adds subscription with a new secret; the secret is only output in this response
+func handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+
+	h, err := webhookEntity.FromForm(ctx)
+	if err != nil {
+		ctx.PrintError(w, err, errorStatus(err))
+		return
+	}
+	name, _ := h.data[webhookEntity.fields["entity"]].(string)
+	if e, ok := Entities[name]; !ok || !e.Webhooks {
+		ctx.PrintError(w, fmt.Errorf(ErrWebhookEntityNotValid, name), http.StatusBadRequest)
+		return
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+	h.data[webhookEntity.fields["secret"]] = secret
+
+	ctx, key := webhookEntity.NewIncompleteKey(ctx)
+	if _, err = webhookEntity.Add(ctx, key, h); err != nil {
+		if err == ErrNotAuthorized {
+			ctx.PrintError(w, err, http.StatusForbidden)
+			return
+		}
+		ctx.PrintError(w, err, errorStatus(err))
+		return
+	}
+
+	out := h.Output(ctx)
+	out["secret"] = secret
+	ctx.Print(w, out)
+}
+
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+	ctx, key, err := webhookEntity.DecodeKey(ctx, mux.Vars(r)["encodedKey"])
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = webhookEntity.Delete(ctx, key)
+	if err == ErrNotAuthorized {
+		ctx.PrintError(w, err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ctx.Print(w, "success")
+}
+
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := NewContext(r)
+	ctx, key, err := webhookEntity.DecodeKey(ctx, mux.Vars(r)["encodedKey"])
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	hs, nextCursor, err := webhookDeliveryEntity.Query(ctx, "-_createdAt", limit, 0, q.Get("cursor"),
+		EntityQueryFilter{Name: "webhook", Operator: "=", Value: key})
+	if err == ErrNotAuthorized {
+		ctx.PrintError(w, err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		ctx.PrintError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var data []map[string]interface{}
+	for _, h := range hs {
+		data = append(data, h.Output(ctx))
+	}
+	ctx.Print(w, map[string]interface{}{
+		"data":       data,
+		"count":      len(data),
+		"nextCursor": nextCursor,
+	})
+}
@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// HMAC-SHA256 of "1700000000.{"a":1}" with key "secret"
+	const want = "49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got := signWebhook("secret", "1700000000", []byte(`{"a":1}`)); got != want {
+		t.Fatalf("signature is %s, want %s", got, want)
+	}
+	if got := signWebhook("other", "1700000000", []byte(`{"a":1}`)); got == want {
+		t.Fatal("signature doesn't depend on secret")
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	ctx := useStore(NewMemoryStore())
+	e := &Entity{Name: "webhookItem", Fields: []*Field{
+		{Name: "title"},
+		{Name: "city", GroupName: "address"},
+	}}
+	if _, err := e.init(); err != nil {
+		t.Fatal(err)
+	}
+	key := addTestItem(t, ctx, e, map[string]interface{}{"title": "a", "address[city]": "Ljubljana"})
+
+	payload := func(op string, ev *ChangeEvent) map[string]interface{} {
+		body, err := webhookPayload(ctx.Context, ev, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err = json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		return p.Data
+	}
+
+	// data is output by API from the stored entity
+	data := payload(WebhookEdit, &ChangeEvent{Id: "1", Entity: e.Name, Key: key, Time: time.Now()})
+	if data["title"] != "a" || data["_id"] != key.Encode() {
+		t.Fatalf("payload data is %v, want API output", data)
+	}
+	if address, _ := data["address"].(map[string]interface{}); address["city"] != "Ljubljana" {
+		t.Fatalf("payload data is %v, want grouped address", data)
+	}
+
+	// deleted entities are sent as they were before the delete
+	before := map[string]interface{}{"title": "old"}
+	data = payload(WebhookDelete, &ChangeEvent{Id: "2", Entity: e.Name, Key: key, Time: time.Now(), Before: before})
+	if data["title"] != "old" {
+		t.Fatalf("payload data of delete is %v, want data before delete", data)
+	}
+}